@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Gateway calls contract functions through the Kalp gateway API
+type Gateway struct {
+	URL           string // Base URL, such as https://gateway-api.kalp.studio
+	APIKey        string
+	Network       string
+	WalletAddress string
+	Client        *http.Client
+}
+
+type gatewayRequest struct {
+	Network       string                 `json:"network"`
+	Blockchain    string                 `json:"blockchain"`
+	WalletAddress string                 `json:"walletAddress"`
+	Args          map[string]interface{} `json:"args"`
+}
+
+type gatewayResponse struct {
+	Message string `json:"message"`
+	Result  struct {
+		Result        json.RawMessage `json:"result"`
+		Success       bool            `json:"success"`
+		TransactionId string          `json:"transactionId"`
+	} `json:"result"`
+}
+
+// Query evaluates a contract function without submitting a transaction and decodes its result into v
+func (g *Gateway) Query(contractId string, function string, args map[string]interface{}, v interface{}) error {
+	response, err := g.call("query", contractId, function, args)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(response.Result.Result, v)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", function, err)
+	}
+
+	return nil
+}
+
+// Invoke submits a transaction and returns its transaction id
+func (g *Gateway) Invoke(contractId string, function string, args map[string]interface{}) (string, error) {
+	response, err := g.call("invoke", contractId, function, args)
+	if err != nil {
+		return "", err
+	}
+	if !response.Result.Success {
+		return "", fmt.Errorf("%s was not committed: %s", function, response.Message)
+	}
+
+	return response.Result.TransactionId, nil
+}
+
+func (g *Gateway) call(method string, contractId string, function string, args map[string]interface{}) (*gatewayResponse, error) {
+	requestBytes, err := json.Marshal(gatewayRequest{
+		Network:       g.Network,
+		Blockchain:    "KALP",
+		WalletAddress: g.WalletAddress,
+		Args:          args,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/contract/kalp/%s/%s/%s", strings.TrimSuffix(g.URL, "/"), method, contractId, function)
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("x-api-key", g.APIKey)
+
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResponse, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", function, err)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBytes, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %w", function, err)
+	}
+
+	response := new(gatewayResponse)
+	err = json.Unmarshal(responseBytes, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s response (status %d): %w", function, httpResponse.StatusCode, err)
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s failed with status %d: %s", function, httpResponse.StatusCode, response.Message)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,48 @@
+// Command estatex-migrate copies the NFTs, sales, balances, config and related records of one marketplace
+// deployment to a newly deployed contract through the Kalp gateway, using ExportState
+// and ImportState. Run it before the new marketplace is opened.
+//
+// Usage:
+//
+//	estatex-migrate -from <old contract id> -to <new contract id> -wallet <admin wallet>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	gateway := &Gateway{}
+	migration := &Migration{Gateway: gateway, Out: os.Stdout}
+	open := flag.Bool("open", false, "open the new marketplace once the import completes")
+	flag.StringVar(&gateway.URL, "gateway", "https://gateway-api.kalp.studio", "gateway API base URL")
+	flag.StringVar(&gateway.APIKey, "api-key", os.Getenv("KALP_API_KEY"), "gateway API key, defaults to $KALP_API_KEY")
+	flag.StringVar(&gateway.Network, "network", "TESTNET", "Kalp network")
+	flag.StringVar(&gateway.WalletAddress, "wallet", "", "wallet address of the contract admin")
+	flag.StringVar(&migration.From, "from", "", "contract id of the old deployment")
+	flag.StringVar(&migration.To, "to", "", "contract id of the new deployment")
+	flag.BoolVar(&migration.DryRun, "dry-run", false, "export every page without importing it")
+	flag.Parse()
+
+	if migration.From == "" || migration.To == "" || gateway.WalletAddress == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := migration.Run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *open && !migration.DryRun {
+		_, err = gateway.Invoke(migration.To, "OpenMarketplace", map[string]interface{}{})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("opened the marketplace on", migration.To)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"krc20/contract"
+)
+
+// Migration copies the marketplace state from one contract deployment to another
+type Migration struct {
+	Gateway *Gateway
+	From    string // Contract id of the old deployment
+	To      string // Contract id of the new deployment
+	DryRun  bool   // Export and verify every page without importing it
+	Out     io.Writer
+}
+
+// Run exports every page of every prefix from the old deployment and imports it into the new one
+func (m *Migration) Run() error {
+	total := 0
+	for _, prefix := range contract.SnapshotPrefixes() {
+		bookmark := ""
+		for {
+			page := new(contract.StatePage)
+			err := m.Gateway.Query(m.From, "ExportState", map[string]interface{}{
+				"prefix":   prefix,
+				"bookmark": bookmark,
+			}, page)
+			if err != nil {
+				return fmt.Errorf("failed to export %s after %q: %w", prefix, bookmark, err)
+			}
+
+			if len(page.Entries) > 0 && !m.DryRun {
+				batch, err := json.Marshal(page)
+				if err != nil {
+					return err
+				}
+				txId, err := m.Gateway.Invoke(m.To, "ImportState", map[string]interface{}{"batchJSON": string(batch)})
+				if err != nil {
+					return fmt.Errorf("failed to import %s after %q: %w", prefix, bookmark, err)
+				}
+				fmt.Fprintf(m.Out, "imported %d %s keys in %s\n", len(page.Entries), prefix, txId)
+			} else {
+				fmt.Fprintf(m.Out, "exported %d %s keys\n", len(page.Entries), prefix)
+			}
+
+			total += len(page.Entries)
+			if bookmark = page.Bookmark; bookmark == "" {
+				break
+			}
+		}
+	}
+
+	fmt.Fprintf(m.Out, "migrated %d keys from %s to %s\n", total, m.From, m.To)
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"krc20/contract"
+	"krc20/mockledger"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+const testAdmin = "admin"
+
+// fakeGateway serves ExportState and ImportState for contracts running on in-memory ledgers
+type fakeGateway struct {
+	t         *testing.T
+	contract  *contract.TokenERC721Contract
+	ledgers   map[string]*mockledger.Ledger
+	pageCount int
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// /v1/contract/kalp/<method>/<contract id>/<function>
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 6 {
+		http.NotFound(w, r)
+		return
+	}
+	ledger := g.ledgers[parts[4]]
+
+	var request gatewayRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		g.t.Fatal(err)
+	}
+
+	var result interface{}
+	err := ledger.Submit(request.WalletAddress, func(ctx kalpsdk.TransactionContextInterface) (err error) {
+		switch parts[5] {
+		case "ExportState":
+			result, err = g.contract.ExportState(ctx, request.Args["prefix"].(string), request.Args["bookmark"].(string))
+			g.pageCount++
+		case "ImportState":
+			result, err = g.contract.ImportState(ctx, request.Args["batchJSON"].(string))
+		}
+		return err
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"result": map[string]interface{}{"result": result, "success": true, "transactionId": "tx"},
+	})
+}
+
+func TestMigration(t *testing.T) {
+	c := contract.NewTokenERC721Contract(kalpsdk.Contract{}, testAdmin)
+	source := mockledger.New(time.Unix(0, 0))
+	target := mockledger.New(time.Unix(0, 0))
+
+	err := source.Submit(testAdmin, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := c.Initialize(ctx, "EstateX", "ESX", "inspector")
+		return err
+	})
+	for i := 0; i < 150 && err == nil; i++ {
+		err = source.Submit(testAdmin, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := c.MintWithTokenURIWithDetails(ctx, "", "Villa", "", "", "", "", 0, 0, 0, 0, "", 0)
+			return err
+		})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gateway := &fakeGateway{t: t, contract: c, ledgers: map[string]*mockledger.Ledger{"old": source, "new": target}}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	migration := &Migration{
+		Gateway: &Gateway{URL: server.URL, WalletAddress: testAdmin},
+		From:    "old",
+		To:      "new",
+		Out:     io.Discard,
+	}
+	if err := migration.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 150 NFTs take two pages, the other prefixes one each
+	if want := len(contract.SnapshotPrefixes()) + 1; gateway.pageCount != want {
+		t.Errorf("exported %d pages, want %d", gateway.pageCount, want)
+	}
+	for _, key := range source.Keys() {
+		if key == "name" || key == "symbol" || key == "contractConfig" || key == "marketplaceOpen" {
+			continue
+		}
+		if string(target.Get(key)) != string(source.Get(key)) {
+			t.Errorf("key %q was not migrated", key)
+		}
+	}
+
+	// A second run conflicts with the keys already imported
+	if err := migration.Run(); err == nil {
+		t.Error("second migration succeeded, want a conflict")
+	}
+}
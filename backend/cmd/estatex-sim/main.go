@@ -0,0 +1,59 @@
+// Command estatex-sim replays marketplace scenario scripts against the contract
+// running on an in-process ledger, printing state diffs and events and asserting
+// the expected outcome of every step.
+//
+// Usage:
+//
+//	estatex-sim script.yaml [script.json ...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	quiet := flag.Bool("quiet", false, "only print the result of each script")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-quiet] script.yaml [script.json ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var out io.Writer = os.Stdout
+	if *quiet {
+		out = io.Discard
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		script, err := loadScript(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		failures, err := Run(script, out)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "%s: %v\n", script.Name, err)
+			failed = true
+		case failures > 0:
+			fmt.Printf("FAIL %s: %d failed expectations\n", script.Name, failures)
+			failed = true
+		case *quiet:
+			fmt.Printf("ok   %s\n", script.Name)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"krc20/contract"
+	"krc20/mockledger"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+var contextType = reflect.TypeOf((*kalpsdk.TransactionContextInterface)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Runner replays a script against a fresh contract and ledger
+type Runner struct {
+	out      io.Writer
+	ledger   *mockledger.Ledger
+	contract *contract.TokenERC721Contract
+	failures int
+}
+
+// Run replays the script, printing state diffs and events to out.
+// It returns the number of failed expectations.
+func Run(script *Script, out io.Writer) (int, error) {
+	start, err := script.startTime()
+	if err != nil {
+		return 0, fmt.Errorf("invalid start time: %w", err)
+	}
+
+	r := &Runner{
+		out:      out,
+		ledger:   mockledger.New(start),
+		contract: contract.NewTokenERC721Contract(kalpsdk.Contract{}, script.Deployer),
+	}
+	for _, userID := range script.KYC {
+		r.ledger.SetKYC(userID, true)
+	}
+
+	fmt.Fprintf(out, "=== %s\n", script.Name)
+	for i, step := range script.Steps {
+		err := r.runStep(i+1, step)
+		if err != nil {
+			return r.failures, fmt.Errorf("step %d (%s): %w", i+1, step.Call, err)
+		}
+	}
+
+	if r.failures > 0 {
+		fmt.Fprintf(out, "--- FAIL: %s (%d failed expectations)\n", script.Name, r.failures)
+	} else {
+		fmt.Fprintf(out, "--- PASS: %s\n", script.Name)
+	}
+	return r.failures, nil
+}
+
+func (r *Runner) runStep(number int, step Step) error {
+	if step.Advance != "" {
+		d, err := time.ParseDuration(step.Advance)
+		if err != nil {
+			return fmt.Errorf("invalid advance: %w", err)
+		}
+		r.ledger.Advance(d)
+	}
+
+	fmt.Fprintf(r.out, "\n[%d] %s %s(%s)\n", number, step.As, step.Call, formatArgs(step.Args))
+
+	tx := r.ledger.NewTransaction(step.As)
+	args := make([]string, len(step.Args))
+	for i, arg := range step.Args {
+		args[i] = fmt.Sprint(arg)
+	}
+	tx.SetFunctionAndParameters(step.Call, args)
+
+	result, callErr, err := r.call(tx, step)
+	if err != nil {
+		return err
+	}
+
+	if callErr != nil {
+		fmt.Fprintf(r.out, "    error: %v\n", callErr)
+	} else {
+		r.printDiff(tx)
+		if event := tx.Event(); event != nil {
+			fmt.Fprintf(r.out, "    event %s %s\n", event.Name, event.Payload)
+		}
+		if result != nil {
+			resultBytes, _ := json.Marshal(result)
+			fmt.Fprintf(r.out, "    result: %s\n", resultBytes)
+		}
+		err = r.ledger.Commit(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.check(step, tx, result, callErr)
+}
+
+// call invokes the contract function named by the step, converting the script arguments to its parameter types
+func (r *Runner) call(tx *mockledger.Transaction, step Step) (interface{}, error, error) {
+	method := reflect.ValueOf(r.contract).MethodByName(step.Call)
+	if !method.IsValid() {
+		return nil, nil, fmt.Errorf("unknown contract function %s", step.Call)
+	}
+
+	methodType := method.Type()
+	if methodType.NumIn() == 0 || methodType.In(0) != contextType {
+		return nil, nil, fmt.Errorf("%s is not a transaction function", step.Call)
+	}
+	if methodType.NumIn()-1 != len(step.Args) {
+		return nil, nil, fmt.Errorf("%s takes %d arguments, got %d", step.Call, methodType.NumIn()-1, len(step.Args))
+	}
+
+	in := []reflect.Value{reflect.ValueOf(tx)}
+	for i, arg := range step.Args {
+		value, err := convertArg(arg, methodType.In(i+1))
+		if err != nil {
+			return nil, nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		in = append(in, value)
+	}
+
+	out := method.Call(in)
+	var result interface{}
+	var callErr error
+	for _, value := range out {
+		if value.Type() == errorType {
+			if !value.IsNil() {
+				callErr = value.Interface().(error)
+			}
+			continue
+		}
+		result = value.Interface()
+	}
+
+	return result, callErr, nil
+}
+
+func convertArg(arg interface{}, paramType reflect.Type) (reflect.Value, error) {
+	text := fmt.Sprint(arg)
+	switch paramType.Kind() {
+	case reflect.String:
+		// Structured arguments, such as a PaymentTracker, are passed to the contract as JSON
+		switch arg.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			argBytes, err := json.Marshal(stringKeys(arg))
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			text = string(argBytes)
+		}
+		return reflect.ValueOf(text).Convert(paramType), nil
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not an integer", text)
+		}
+		return reflect.ValueOf(n).Convert(paramType), nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a bool", text)
+		}
+		return reflect.ValueOf(b), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported parameter type %s", paramType)
+	}
+}
+
+func (r *Runner) printDiff(tx *mockledger.Transaction) {
+	for _, key := range tx.WrittenKeys() {
+		value, deleted, _ := tx.Written(key)
+		old := r.ledger.Get(key)
+		name := r.displayKey(tx, key)
+		switch {
+		case deleted:
+			fmt.Fprintf(r.out, "    - %s\n", name)
+		case old == nil:
+			fmt.Fprintf(r.out, "    + %s = %s\n", name, value)
+		case string(old) != string(value):
+			fmt.Fprintf(r.out, "    ~ %s = %s\n", name, value)
+		}
+	}
+}
+
+func (r *Runner) check(step Step, tx *mockledger.Transaction, result interface{}, callErr error) error {
+	expect := step.Expect
+	if expect == nil {
+		expect = &Expect{}
+	}
+
+	if expect.Error != "" {
+		var contractErr *contract.ContractError
+		if !errors.As(callErr, &contractErr) || string(contractErr.Code) != expect.Error {
+			r.fail("want error %s, got %v", expect.Error, callErr)
+		}
+		return nil
+	}
+	if callErr != nil {
+		r.fail("unexpected error: %v", callErr)
+		return nil
+	}
+
+	if expect.Result != nil {
+		want, err := normalize(expect.Result)
+		if err != nil {
+			return err
+		}
+		got, err := normalize(result)
+		if err != nil {
+			return err
+		}
+		if !matches(want, got) {
+			r.fail("result does not match %v", want)
+		}
+	}
+
+	if expect.Events != nil {
+		var got []string
+		if event := tx.Event(); event != nil {
+			got = append(got, event.Name)
+		}
+		if strings.Join(got, ",") != strings.Join(expect.Events, ",") {
+			r.fail("want events %v, got %v", expect.Events, got)
+		}
+	}
+
+	for name, value := range expect.State {
+		key, err := r.ledgerKey(tx, name)
+		if err != nil {
+			return err
+		}
+		stored := r.ledger.Get(key)
+		if value == nil {
+			if stored != nil {
+				r.fail("want %s to be absent, got %s", name, stored)
+			}
+			continue
+		}
+		if stored == nil {
+			r.fail("want %s to exist", name)
+			continue
+		}
+
+		want, err := normalize(value)
+		if err != nil {
+			return err
+		}
+		var got interface{}
+		if json.Unmarshal(stored, &got) != nil {
+			got = string(stored)
+		}
+		if !matches(want, got) {
+			r.fail("%s = %s does not match %v", name, stored, want)
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) fail(format string, args ...interface{}) {
+	r.failures++
+	fmt.Fprintf(r.out, "    FAIL: "+format+"\n", args...)
+}
+
+// displayKey renders a composite key as objectType/attribute/...
+func (r *Runner) displayKey(tx *mockledger.Transaction, key string) string {
+	objectType, attributes, err := tx.SplitCompositeKey(key)
+	if err != nil {
+		return key
+	}
+	return strings.Join(append([]string{objectType}, attributes...), "/")
+}
+
+// ledgerKey parses a key written as objectType/attribute/... back to a composite key
+func (r *Runner) ledgerKey(tx *mockledger.Transaction, name string) (string, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) == 1 {
+		return name, nil
+	}
+	return tx.CreateCompositeKey(parts[0], parts[1:])
+}
+
+func formatArgs(args []interface{}) string {
+	formatted := make([]string, len(args))
+	for i, arg := range args {
+		argBytes, err := json.Marshal(stringKeys(arg))
+		if err != nil {
+			formatted[i] = fmt.Sprint(arg)
+			continue
+		}
+		formatted[i] = string(argBytes)
+	}
+	return strings.Join(formatted, ", ")
+}
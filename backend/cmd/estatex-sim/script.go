@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Script is a marketplace scenario replayed against an in-process ledger.
+// JSON scripts are accepted too, since JSON is a subset of YAML.
+type Script struct {
+	Name     string   `yaml:"name"`
+	Deployer string   `yaml:"deployer"` // Identity the contract is deployed with
+	Start    string   `yaml:"start"`    // RFC 3339 time of the first transaction
+	KYC      []string `yaml:"kyc"`      // Identities that have completed KYC
+	Steps    []Step   `yaml:"steps"`
+}
+
+// Step is one transaction submitted by an identity
+type Step struct {
+	As      string        `yaml:"as"`
+	Call    string        `yaml:"call"`
+	Args    []interface{} `yaml:"args"`
+	Advance string        `yaml:"advance"` // Duration to move the ledger clock forward before the step
+	Expect  *Expect       `yaml:"expect"`
+}
+
+// Expect lists the outcomes asserted after a step. Result and state values are matched
+// as subsets, so a fixture only needs to mention the fields it cares about.
+type Expect struct {
+	Error  string                 `yaml:"error"`  // Error code the step must fail with
+	Result interface{}            `yaml:"result"` // Subset of the JSON result
+	Events []string               `yaml:"events"` // Names of the events the step must emit
+	State  map[string]interface{} `yaml:"state"`  // Subset of the JSON value of each key, null for a missing key
+}
+
+func loadScript(path string) (*Script, error) {
+	scriptBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+
+	script := new(Script)
+	err = yaml.UnmarshalStrict(scriptBytes, script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse script %s: %w", path, err)
+	}
+
+	if script.Deployer == "" {
+		return nil, fmt.Errorf("script %s has no deployer", path)
+	}
+	if script.Name == "" {
+		script.Name = path
+	}
+
+	return script, nil
+}
+
+func (s *Script) startTime() (time.Time, error) {
+	if s.Start == "" {
+		return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Parse(time.RFC3339, s.Start)
+}
+
+// normalize converts a decoded YAML value to the shape encoding/json produces,
+// so fixtures can be compared with contract results
+func normalize(value interface{}) (interface{}, error) {
+	valueBytes, err := json.Marshal(stringKeys(value))
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	err = json.Unmarshal(valueBytes, &normalized)
+	return normalized, err
+}
+
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[key] = stringKeys(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = stringKeys(item)
+		}
+		return converted
+	default:
+		return v
+	}
+}
+
+// matches reports whether got contains want: maps match if every key of want matches,
+// slices match element by element and scalars must be equal
+func matches(want interface{}, got interface{}) bool {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, item := range w {
+			if !matches(item, g[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !matches(w[i], g[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return want == got
+	}
+}
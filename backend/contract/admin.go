@@ -0,0 +1,185 @@
+package contract
+
+import (
+	"encoding/json"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define key name for the contract config persisted by Initialize
+const contractConfigKey = "contractConfig"
+
+// ContractConfig is the contract configuration persisted by Initialize.
+// PendingAdmin is set while an admin handover awaits AcceptAdmin.
+type ContractConfig struct {
+	Name         string `json:"name"`
+	Symbol       string `json:"symbol"`
+	Admin        string `json:"admin"`
+	PendingAdmin string `json:"pendingAdmin"`
+	Inspector    string `json:"inspector"`
+}
+
+// AdminTransfer is the payload of the AdminTransferStarted and AdminTransferred events
+type AdminTransfer struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// GetContractConfig returns the persisted contract config
+func (c *TokenERC721Contract) GetContractConfig(ctx kalpsdk.TransactionContextInterface) (*ContractConfig, error) {
+	return c._readConfig(ctx)
+}
+
+// TransferAdmin allows the admin to nominate a new admin, who takes over once they call AcceptAdmin.
+// Nominating again replaces the pending admin.
+func (c *TokenERC721Contract) TransferAdmin(ctx kalpsdk.TransactionContextInterface, newAdmin string) (bool, error) {
+	clientID, err := c._requireAdmin(ctx, "transfer the admin role")
+	if err != nil {
+		return false, err
+	}
+
+	if newAdmin == "" {
+		return false, newError(CodeValidation, "new admin must not be empty")
+	}
+
+	config, err := c._readConfig(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	config.PendingAdmin = newAdmin
+	err = _putJSON(ctx, contractConfigKey, config)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for contract config")
+	}
+
+	err = _setJSONEvent(ctx, "AdminTransferStarted", AdminTransfer{From: clientID, To: newAdmin})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AcceptAdmin allows the pending admin nominated by TransferAdmin to take over the admin role
+func (c *TokenERC721Contract) AcceptAdmin(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get client identity")
+	}
+
+	config, err := c._readConfig(ctx)
+	if err != nil {
+		return false, err
+	}
+	if config.PendingAdmin == "" || clientID != config.PendingAdmin {
+		return false, newError(CodeUnauthorized, "only the pending admin can accept the admin role")
+	}
+
+	oldAdmin := config.Admin
+	config.Admin = clientID
+	config.PendingAdmin = ""
+	err = _putJSON(ctx, contractConfigKey, config)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for contract config")
+	}
+
+	err = _setJSONEvent(ctx, "AdminTransferred", AdminTransfer{From: oldAdmin, To: clientID})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// _readConfig returns the persisted contract config. Deployments initialized before the config
+// was persisted fall back to the deployer as admin and the original inspector.
+func (c *TokenERC721Contract) _readConfig(ctx kalpsdk.TransactionContextInterface) (*ContractConfig, error) {
+	config := new(ContractConfig)
+	found, err := _getJSON(ctx, contractConfigKey, config)
+	if err != nil {
+		return nil, wrapError(err, "failed to get contract config")
+	}
+	if !found {
+		return &ContractConfig{Admin: c.deployer, Inspector: inspectorAddress}, nil
+	}
+
+	return config, nil
+}
+
+// _requireAdmin returns the caller's identity if the caller is the admin
+func (c *TokenERC721Contract) _requireAdmin(ctx kalpsdk.TransactionContextInterface, action string) (string, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return "", wrapError(err, "failed to get client identity")
+	}
+
+	config, err := c._readConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	if clientID != config.Admin {
+		return "", newError(CodeUnauthorized, "only the admin can %s", action)
+	}
+
+	return clientID, nil
+}
+
+// _requireAdminOrRole returns the caller's identity if the caller is the admin or has been granted the role
+func (c *TokenERC721Contract) _requireAdminOrRole(ctx kalpsdk.TransactionContextInterface, role string, action string) (string, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return "", wrapError(err, "failed to get client identity")
+	}
+
+	config, err := c._readConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	if clientID == config.Admin {
+		return clientID, nil
+	}
+
+	hasRole, err := _hasRole(ctx, role, clientID)
+	if err != nil {
+		return "", err
+	}
+	if !hasRole {
+		return "", newError(CodeUnauthorized, "only the admin or a user with the %s role can %s", role, action)
+	}
+
+	return clientID, nil
+}
+
+// _requireInspector returns the caller's identity if the caller is the inspector
+func (c *TokenERC721Contract) _requireInspector(ctx kalpsdk.TransactionContextInterface, action string) (string, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return "", wrapError(err, "failed to get client identity")
+	}
+
+	config, err := c._readConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	if clientID != config.Inspector {
+		return "", newError(CodeUnauthorized, "only the inspector can %s", action)
+	}
+
+	return clientID, nil
+}
+
+// _setJSONEvent emits an event with a JSON payload. Fabric keeps only the last event of a transaction.
+func _setJSONEvent(ctx kalpsdk.TransactionContextInterface, name string, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return wrapError(err, "failed to marshal %s event", name)
+	}
+
+	err = ctx.SetEvent(name, payloadBytes)
+	if err != nil {
+		return wrapError(err, "failed to set %s event", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package contract
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for the Merkle proofs buyers have submitted for private listings
+const allowlistProofPrefix = "allowlistProof"
+
+// Define objectType name for the buyer lists of private listings, kept in the saleTermsCollection
+const saleAllowlistPrefix = "saleAllowlist"
+
+// SaleAllowlist restricts a listing to pre-qualified buyers. Buyers lists them directly; the list goes to
+// the saleTermsCollection private data collection and the public sale only keeps its Merkle root as BuyersRoot.
+// For large lists MerkleRoot holds the hex root of a Merkle tree over them and each buyer submits a proof with ProveAllowlisted.
+//
+// Leaves are the SHA-256 of the buyer ID and each parent is the SHA-256 of its two children in
+// ascending byte order, so a proof is just the list of sibling hashes from the leaf up.
+type SaleAllowlist struct {
+	Buyers     []string `json:"buyers,omitempty"`     // Never on the public record of the sale
+	BuyersRoot string   `json:"buyersRoot,omitempty"` // Set by the contract when Buyers is moved to private data
+	MerkleRoot string   `json:"merkleRoot,omitempty"`
+}
+
+// AllowlistProof records that a buyer has proven membership of the Merkle root of a private listing
+type AllowlistProof struct {
+	TokenId    string `json:"tokenId"`
+	Buyer      string `json:"buyer"`
+	MerkleRoot string `json:"merkleRoot"`
+	ProvenAt   int64  `json:"provenAt"` // Unix seconds
+}
+
+// ListNFTForPrivateSale allows the owner to list their NFT for sale to the buyers on an allowlist only.
+// buyersJSON is a JSON array of buyer IDs; merkleRoot may be given instead of or in addition to it.
+func (c *TokenERC721Contract) ListNFTForPrivateSale(ctx kalpsdk.TransactionContextInterface, tokenId string, price int, buyersJSON string, merkleRoot string) (bool, error) {
+	ownerID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get owner identity")
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return false, wrapError(err, "failed to read NFT")
+	}
+
+	if nft.Owner != ownerID {
+		return false, newError(CodeUnauthorized, "only the owner can list the NFT for sale")
+	}
+
+	err = _requireSoleOwner(ctx, tokenId, ActionList)
+	if err != nil {
+		return false, err
+	}
+
+	allowlist := &SaleAllowlist{MerkleRoot: merkleRoot}
+	if buyersJSON != "" {
+		err = json.Unmarshal([]byte(buyersJSON), &allowlist.Buyers)
+		if err != nil {
+			return false, wrapErrorAs(CodeValidation, err, "failed to unmarshal allowlist buyers")
+		}
+	}
+
+	err = _listNFT(ctx, nft, &Sale{Price: price, LeaseSurvivesSale: true, Allowlist: allowlist})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ProveAllowlisted allows a buyer to prove with a Merkle proof that they are on the allowlist of a private listing.
+// proofJSON is a JSON array of hex sibling hashes. Once proven, the buyer can see and buy the listing.
+func (c *TokenERC721Contract) ProveAllowlisted(ctx kalpsdk.TransactionContextInterface, tokenId string, proofJSON string) (bool, error) {
+	buyerID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get buyer identity")
+	}
+
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+	if !sale.IsOnSale || sale.Allowlist == nil || sale.Allowlist.MerkleRoot == "" {
+		return false, newError(CodeInvalidState, "NFT has no private listing with a Merkle root").WithDetail("tokenId", tokenId)
+	}
+
+	var proof []string
+	err = json.Unmarshal([]byte(proofJSON), &proof)
+	if err != nil {
+		return false, wrapErrorAs(CodeValidation, err, "failed to unmarshal allowlist proof")
+	}
+
+	valid, err := _verifyMerkleProof(sale.Allowlist.MerkleRoot, buyerID, proof)
+	if err != nil {
+		return false, err
+	}
+	if !valid {
+		return false, newError(CodeUnauthorized, "allowlist proof does not match the listing")
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	proofKey, err := _compositeKey(ctx, allowlistProofPrefix, tokenId, buyerID)
+	if err != nil {
+		return false, err
+	}
+	err = _putJSON(ctx, proofKey, AllowlistProof{TokenId: tokenId, Buyer: buyerID, MerkleRoot: sale.Allowlist.MerkleRoot, ProvenAt: now})
+	if err != nil {
+		return false, wrapError(err, "failed to put state for allowlist proof")
+	}
+
+	return true, nil
+}
+
+// AllowlistMerkleRoot returns the Merkle root of an allowlist, for sellers building a private listing off-chain
+func AllowlistMerkleRoot(buyers []string) string {
+	if len(buyers) == 0 {
+		return ""
+	}
+
+	level := make([][]byte, len(buyers))
+	for i, buyer := range buyers {
+		level[i] = _merkleLeaf(buyer)
+	}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			// An odd node out is carried up unchanged
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, _merkleParent(level[i], level[i+1]))
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0])
+}
+
+// AllowlistMerkleProof returns the proof of a buyer on an allowlist, or nil if they are not on it
+func AllowlistMerkleProof(buyers []string, buyer string) []string {
+	index := -1
+	level := make([][]byte, len(buyers))
+	for i, candidate := range buyers {
+		level[i] = _merkleLeaf(candidate)
+		if candidate == buyer {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil
+	}
+
+	proof := []string{}
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, hex.EncodeToString(level[sibling]))
+		}
+
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, _merkleParent(level[i], level[i+1]))
+		}
+		level = next
+		index /= 2
+	}
+
+	return proof
+}
+
+// _requireAllowlisted fails if the sale is private and the buyer is neither on its list nor has proven membership of its root
+func _requireAllowlisted(ctx kalpsdk.TransactionContextInterface, sale *Sale, buyerID string) error {
+	allowed, err := _isAllowlisted(ctx, sale, buyerID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return newError(CodeUnauthorized, "NFT is listed for private sale and the buyer is not on its allowlist").WithDetail("tokenId", sale.TokenId)
+	}
+
+	return nil
+}
+
+func _isAllowlisted(ctx kalpsdk.TransactionContextInterface, sale *Sale, userID string) (bool, error) {
+	if sale.Allowlist == nil || userID == sale.Seller {
+		return true, nil
+	}
+
+	// Listings made before the buyers were moved to private data keep them on the sale
+	buyers := sale.Allowlist.Buyers
+	if sale.Allowlist.BuyersRoot != "" {
+		buyersKey, err := _compositeKey(ctx, saleAllowlistPrefix, sale.TokenId)
+		if err != nil {
+			return false, err
+		}
+		found, err := _getPrivateCopy(ctx, buyersKey, &buyers)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			return false, newError(CodeInternal, "allowlist buyers are missing from the private data collection").WithDetail("tokenId", sale.TokenId)
+		}
+	}
+	for _, buyer := range buyers {
+		if buyer == userID {
+			return true, nil
+		}
+	}
+
+	if sale.Allowlist.MerkleRoot == "" {
+		return false, nil
+	}
+	proofKey, err := _compositeKey(ctx, allowlistProofPrefix, sale.TokenId, userID)
+	if err != nil {
+		return false, err
+	}
+	proof := new(AllowlistProof)
+	found, err := _getJSON(ctx, proofKey, proof)
+	if err != nil {
+		return false, wrapError(err, "failed to get allowlist proof")
+	}
+
+	// A proof only counts for the root it was checked against
+	return found && proof.MerkleRoot == sale.Allowlist.MerkleRoot, nil
+}
+
+// _putAllowlistBuyers moves the buyers of a private listing to the private data collection, leaving
+// their Merkle root on the sale
+func _putAllowlistBuyers(ctx kalpsdk.TransactionContextInterface, sale *Sale) error {
+	if sale.Allowlist == nil || len(sale.Allowlist.Buyers) == 0 {
+		return nil
+	}
+
+	buyersKey, err := _compositeKey(ctx, saleAllowlistPrefix, sale.TokenId)
+	if err != nil {
+		return err
+	}
+	err = _putPrivateCopy(ctx, buyersKey, sale.Allowlist.Buyers)
+	if err != nil {
+		return err
+	}
+
+	sale.Allowlist = &SaleAllowlist{BuyersRoot: AllowlistMerkleRoot(sale.Allowlist.Buyers), MerkleRoot: sale.Allowlist.MerkleRoot}
+	return nil
+}
+
+// public returns the allowlist without the buyers of listings made before they were moved to private data
+func (a *SaleAllowlist) public() *SaleAllowlist {
+	if a == nil {
+		return nil
+	}
+	return &SaleAllowlist{BuyersRoot: a.BuyersRoot, MerkleRoot: a.MerkleRoot}
+}
+
+func _validateAllowlist(allowlist *SaleAllowlist) error {
+	if len(allowlist.Buyers) == 0 && allowlist.MerkleRoot == "" {
+		return newError(CodeValidation, "a private listing needs allowlist buyers or a Merkle root")
+	}
+	if allowlist.BuyersRoot != "" {
+		return newError(CodeValidation, "the buyers root of an allowlist is set by the contract")
+	}
+	for _, buyer := range allowlist.Buyers {
+		if buyer == "" {
+			return newError(CodeValidation, "allowlist buyers must not be empty")
+		}
+	}
+	if allowlist.MerkleRoot != "" {
+		root, err := hex.DecodeString(allowlist.MerkleRoot)
+		if err != nil || len(root) != sha256.Size {
+			return newError(CodeValidation, "Merkle root must be a hex SHA-256 hash").WithDetail("merkleRoot", allowlist.MerkleRoot)
+		}
+	}
+	return nil
+}
+
+func _verifyMerkleProof(root string, buyerID string, proof []string) (bool, error) {
+	node := _merkleLeaf(buyerID)
+	for _, siblingHex := range proof {
+		sibling, err := hex.DecodeString(siblingHex)
+		if err != nil || len(sibling) != sha256.Size {
+			return false, newError(CodeValidation, "allowlist proof entries must be hex SHA-256 hashes")
+		}
+		node = _merkleParent(node, sibling)
+	}
+
+	return hex.EncodeToString(node) == root, nil
+}
+
+func _merkleLeaf(buyerID string) []byte {
+	leaf := sha256.Sum256([]byte(buyerID))
+	return leaf[:]
+}
+
+func _merkleParent(a []byte, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	parent := sha256.Sum256(append(append([]byte{}, a...), b...))
+	return parent[:]
+}
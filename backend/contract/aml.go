@@ -0,0 +1,384 @@
+package contract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define key name for the AML thresholds
+const amlThresholdsKey = "amlThresholds"
+
+// Define objectType name for approvals of sales that crossed an AML threshold
+const highValueApprovalPrefix = "highValueApproval"
+
+// Define role name of the users who sign off sales above the director threshold
+const directorRole = "director"
+
+// Review tiers of a sale, lowest first
+const (
+	TierInspector  = "inspector"
+	TierCompliance = "compliance"
+	TierDirector   = "director"
+)
+
+// AMLThresholds are the sale values from which the compliance and director tiers have to sign off
+// after the inspector. A zero threshold turns the tier off.
+type AMLThresholds struct {
+	Compliance int `json:"compliance"`
+	Director   int `json:"director"`
+}
+
+// SignOff is the approval of a sale by one review tier
+type SignOff struct {
+	Tier     string `json:"tier"`
+	SignedBy string `json:"signedBy"`
+	SignedAt int64  `json:"signedAt"` // Unix seconds
+	TxId     string `json:"txId"`
+}
+
+// HighValueApproval records a completed sale that needed sign-offs above the inspector
+type HighValueApproval struct {
+	TokenId      string     `json:"tokenId"`
+	Seller       string     `json:"seller"`
+	Buyer        string     `json:"buyer,omitempty"` // Empty on the public record of a confidential sale
+	Value        int        `json:"value"`           // Higher of price and earnest money, 0 on the public record of a confidential sale
+	Tiers        []string   `json:"tiers"`
+	SignOffs     []*SignOff `json:"signOffs"`
+	ApprovedAt   int64      `json:"approvedAt"` // Unix seconds
+	TxId         string     `json:"txId"`
+	Confidential bool       `json:"confidential,omitempty"`
+}
+
+// HighValueReport lists the high-value approvals in a time range for export to the compliance team
+type HighValueReport struct {
+	From      int64                `json:"from"` // Unix seconds, inclusive
+	To        int64                `json:"to"`   // Unix seconds, exclusive
+	Approvals []*HighValueApproval `json:"approvals"`
+	Checksum  string               `json:"checksum"` // SHA-256 of the approvals
+}
+
+// SetAMLThresholds allows the admin to set the sale values from which the compliance officer,
+// and then a director, have to sign off a sale after the inspector
+func (c *TokenERC721Contract) SetAMLThresholds(ctx kalpsdk.TransactionContextInterface, compliance int, director int) (bool, error) {
+	_, err := c._requireAdmin(ctx, "set the AML thresholds")
+	if err != nil {
+		return false, err
+	}
+
+	if compliance < 0 || director < 0 {
+		return false, newError(CodeValidation, "AML thresholds must not be negative")
+	}
+	if compliance > 0 && director > 0 && director < compliance {
+		return false, newError(CodeValidation, "director threshold must not be below the compliance threshold")
+	}
+
+	err = _putJSON(ctx, amlThresholdsKey, AMLThresholds{Compliance: compliance, Director: director})
+	if err != nil {
+		return false, wrapError(err, "failed to put state for AML thresholds")
+	}
+
+	return true, nil
+}
+
+// GetAMLThresholds returns the AML thresholds
+func (c *TokenERC721Contract) GetAMLThresholds(ctx kalpsdk.TransactionContextInterface) (*AMLThresholds, error) {
+	return _readAMLThresholds(ctx)
+}
+
+// SignOffSale allows the compliance officer or a director to sign off a sale awaiting their tier.
+// It returns true if this was the last sign-off and the NFT was transferred to the buyer.
+func (c *TokenERC721Contract) SignOffSale(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+		return _signOffSale(ctx, tokenId)
+	})
+}
+
+func _signOffSale(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	err := _requireNotPaused(ctx, ScopeApprove)
+	if err != nil {
+		return false, err
+	}
+
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+	if !sale.IsPendingApproval || sale.AwaitingTier == "" {
+		return false, newError(CodeInvalidState, "NFT has no sale awaiting sign-off").WithDetail("tokenId", tokenId)
+	}
+
+	role := complianceRole
+	if sale.AwaitingTier == TierDirector {
+		role = directorRole
+	}
+	clientID, err := _requireRole(ctx, role)
+	if err != nil {
+		return false, err
+	}
+
+	err = _requireNoOpenDispute(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	signOff, err := _signOff(ctx, sale, sale.AwaitingTier, clientID)
+	if err != nil {
+		return false, err
+	}
+
+	completed, err := _advanceApproval(ctx, sale)
+	if err != nil {
+		return false, err
+	}
+	if !completed {
+		err = _setJSONEvent(ctx, "SaleSignedOff", signOff)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = _putSale(ctx, sale)
+	if err != nil {
+		return false, wrapError(err, "failed to update sale state")
+	}
+
+	return completed, nil
+}
+
+// ExportHighValueApprovals allows the admin or a compliance officer to export the sales approved
+// in [from, to) that crossed an AML threshold, including the terms of confidential sales
+func (c *TokenERC721Contract) ExportHighValueApprovals(ctx kalpsdk.TransactionContextInterface, from int64, to int64) (*HighValueReport, error) {
+	_, err := c._requireAdminOrRole(ctx, complianceRole, "export high-value approvals")
+	if err != nil {
+		return nil, err
+	}
+
+	if to <= from {
+		return nil, newError(CodeValidation, "report range must end after it starts")
+	}
+
+	approvals, err := _listJSON[HighValueApproval](ctx, highValueApprovalPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &HighValueReport{From: from, To: to, Approvals: []*HighValueApproval{}}
+	for _, approval := range approvals {
+		if approval.ApprovedAt < from || approval.ApprovedAt >= to {
+			continue
+		}
+		if approval.Confidential {
+			approval, err = _loadHighValueApproval(ctx, approval)
+			if err != nil {
+				return nil, err
+			}
+		}
+		report.Approvals = append(report.Approvals, approval)
+	}
+
+	approvalsBytes, err := json.Marshal(report.Approvals)
+	if err != nil {
+		return nil, wrapError(err, "failed to marshal high-value approvals")
+	}
+	sum := sha256.Sum256(approvalsBytes)
+	report.Checksum = hex.EncodeToString(sum[:])
+
+	return report, nil
+}
+
+// _advanceApproval moves an approved sale to the next tier that has to sign off. Once every required
+// tier has signed off and every condition is satisfied it completes the sale and returns true.
+func _advanceApproval(ctx kalpsdk.TransactionContextInterface, sale *Sale) (bool, error) {
+	tiers, err := _requiredTiers(ctx, sale)
+	if err != nil {
+		return false, err
+	}
+
+	awaiting := ""
+	for _, tier := range tiers {
+		if sale.signedOff(tier) == nil {
+			awaiting = tier
+			break
+		}
+	}
+
+	// Each tier gets a full review period
+	if awaiting != "" && awaiting != sale.AwaitingTier {
+		now, err := _txUnixTime(ctx)
+		if err != nil {
+			return false, err
+		}
+		reviewPeriod, err := _readReviewPeriod(ctx)
+		if err != nil {
+			return false, err
+		}
+		sale.ReviewDeadline = now + reviewPeriod
+	}
+	sale.AwaitingTier = awaiting
+	if awaiting != "" {
+		return false, nil
+	}
+
+	for _, condition := range sale.Conditions {
+		if !condition.Satisfied {
+			return false, nil
+		}
+	}
+
+	err = _completeSale(ctx, sale)
+	if err != nil {
+		return false, err
+	}
+
+	if len(tiers) > 1 {
+		err = _recordHighValueApproval(ctx, sale, tiers)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// _requiredTiers returns the tiers that have to sign off the sale, lowest first
+func _requiredTiers(ctx kalpsdk.TransactionContextInterface, sale *Sale) ([]string, error) {
+	thresholds, err := _readAMLThresholds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	value := sale.value()
+	tiers := []string{TierInspector}
+	if thresholds.Compliance > 0 && value >= thresholds.Compliance {
+		tiers = append(tiers, TierCompliance)
+	}
+	if thresholds.Director > 0 && value >= thresholds.Director {
+		tiers = append(tiers, TierDirector)
+	}
+
+	return tiers, nil
+}
+
+// _signOff records the sign-off of a tier on the sale
+func _signOff(ctx kalpsdk.TransactionContextInterface, sale *Sale, tier string, signedBy string) (*SignOff, error) {
+	if sale.signedOff(tier) != nil {
+		return nil, newError(CodeConflict, "the %s tier has already signed off the sale", tier).WithDetail("tokenId", sale.TokenId)
+	}
+
+	// Each tier is an independent review, so a user holding several roles signs off only one of them
+	for _, signOff := range sale.SignOffs {
+		if signOff.SignedBy == signedBy {
+			return nil, newError(CodeConflict, "%s has already signed off the %s tier of the sale", signedBy, signOff.Tier).
+				WithDetail("tokenId", sale.TokenId)
+		}
+	}
+
+	// Past the deadline the buyer may already be reclaiming the earnest money
+	expired, err := _reviewExpired(ctx, sale)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, newError(CodeInvalidState, "the review deadline has passed").WithDetail("reviewDeadline", sale.ReviewDeadline)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	signOff := &SignOff{Tier: tier, SignedBy: signedBy, SignedAt: now, TxId: ctx.GetTxID()}
+	sale.SignOffs = append(sale.SignOffs, signOff)
+	return signOff, nil
+}
+
+// value returns the amount checked against the AML thresholds
+func (s *Sale) value() int {
+	if s.Earnest > s.Price {
+		return s.Earnest
+	}
+	return s.Price
+}
+
+// signedOff returns the sign-off of tier, or nil if the tier has not signed off
+func (s *Sale) signedOff(tier string) *SignOff {
+	for _, signOff := range s.SignOffs {
+		if signOff.Tier == tier {
+			return signOff
+		}
+	}
+	return nil
+}
+
+func _recordHighValueApproval(ctx kalpsdk.TransactionContextInterface, sale *Sale, tiers []string) error {
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	approval := &HighValueApproval{
+		TokenId:      sale.TokenId,
+		Seller:       sale.Seller,
+		Buyer:        sale.Buyer,
+		Value:        sale.value(),
+		Tiers:        tiers,
+		SignOffs:     sale.SignOffs,
+		ApprovedAt:   now,
+		TxId:         ctx.GetTxID(),
+		Confidential: sale.Confidential,
+	}
+	approvalKey, err := _compositeKey(ctx, highValueApprovalPrefix, _sortableTime(now), approval.TxId)
+	if err != nil {
+		return err
+	}
+
+	public := approval
+	if approval.Confidential {
+		err = _putPrivateCopy(ctx, approvalKey, approval)
+		if err != nil {
+			return err
+		}
+		redacted := *approval
+		redacted.Buyer = ""
+		redacted.Value = 0
+		public = &redacted
+	}
+
+	err = _putJSON(ctx, approvalKey, public)
+	if err != nil {
+		return wrapError(err, "failed to put state for high-value approval")
+	}
+
+	return nil
+}
+
+func _loadHighValueApproval(ctx kalpsdk.TransactionContextInterface, approval *HighValueApproval) (*HighValueApproval, error) {
+	approvalKey, err := _compositeKey(ctx, highValueApprovalPrefix, _sortableTime(approval.ApprovedAt), approval.TxId)
+	if err != nil {
+		return nil, err
+	}
+
+	private := new(HighValueApproval)
+	found, err := _getPrivateCopy(ctx, approvalKey, private)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, newError(CodeInternal, "high-value approval is missing from the private data collection").WithDetail("txId", approval.TxId)
+	}
+
+	return private, nil
+}
+
+func _readAMLThresholds(ctx kalpsdk.TransactionContextInterface) (*AMLThresholds, error) {
+	thresholds := new(AMLThresholds)
+	_, err := _getJSON(ctx, amlThresholdsKey, thresholds)
+	if err != nil {
+		return nil, wrapError(err, "failed to get AML thresholds")
+	}
+
+	return thresholds, nil
+}
@@ -0,0 +1,158 @@
+package contract
+
+import (
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for appraisals
+const appraisalPrefix = "appraisal"
+
+// Define key name for the price band policy
+const priceBandPolicyKey = "priceBandPolicy"
+
+// Define role name of the users who can record appraisals
+const appraiserRole = "appraiser"
+
+// Modes of the price band policy
+const (
+	PriceBandOff    = "off"    // Prices are not checked
+	PriceBandFlag   = "flag"   // Prices outside the band are accepted and flagged for the inspector
+	PriceBandReject = "reject" // Prices outside the band are rejected
+)
+
+// Appraisal is a valuation of the property behind an NFT
+type Appraisal struct {
+	TokenId     string `json:"tokenId"`
+	Value       int    `json:"value"`
+	AppraiserId string `json:"appraiserId"` // License or registration id of the appraiser
+	ReportHash  string `json:"reportHash"`
+	RecordedBy  string `json:"recordedBy"`
+	RecordedAt  int64  `json:"recordedAt"` // Unix seconds
+	TxId        string `json:"txId"`
+}
+
+// PriceBandPolicy checks listing prices and offers against the latest appraisal
+type PriceBandPolicy struct {
+	Mode        string `json:"mode"`
+	BasisPoints int    `json:"basisPoints"` // Allowed deviation from the latest appraisal
+}
+
+// RecordAppraisal allows an appraiser to add a valuation to the NFT's appraisal history
+func (c *TokenERC721Contract) RecordAppraisal(ctx kalpsdk.TransactionContextInterface, tokenId string, value int, appraiserId string, reportHash string) (*Appraisal, error) {
+	clientID, err := _requireRole(ctx, appraiserRole)
+	if err != nil {
+		return nil, err
+	}
+
+	if value <= 0 {
+		return nil, newError(CodeValidation, "appraised value must be positive")
+	}
+	if appraiserId == "" || reportHash == "" {
+		return nil, newError(CodeValidation, "appraiserId and reportHash must not be empty")
+	}
+
+	exists, err := _nftExists(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNFTNotFound(tokenId)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appraisal := &Appraisal{
+		TokenId:     tokenId,
+		Value:       value,
+		AppraiserId: appraiserId,
+		ReportHash:  reportHash,
+		RecordedBy:  clientID,
+		RecordedAt:  now,
+		TxId:        ctx.GetTxID(),
+	}
+	appraisalKey, err := _compositeKey(ctx, appraisalPrefix, tokenId, _sortableTime(now), appraisal.TxId)
+	if err != nil {
+		return nil, err
+	}
+	err = _putJSON(ctx, appraisalKey, appraisal)
+	if err != nil {
+		return nil, wrapError(err, "failed to put state for appraisal")
+	}
+
+	return appraisal, nil
+}
+
+// GetAppraisals returns the appraisal history of the NFT, oldest first
+func (c *TokenERC721Contract) GetAppraisals(ctx kalpsdk.TransactionContextInterface, tokenId string) ([]*Appraisal, error) {
+	return _listJSON[Appraisal](ctx, appraisalPrefix, tokenId)
+}
+
+// SetPriceBandPolicy allows the admin to flag or reject listing prices and offers that deviate from
+// the latest appraisal by more than basisPoints
+func (c *TokenERC721Contract) SetPriceBandPolicy(ctx kalpsdk.TransactionContextInterface, mode string, basisPoints int) (bool, error) {
+	_, err := c._requireAdmin(ctx, "set the price band policy")
+	if err != nil {
+		return false, err
+	}
+
+	if mode != PriceBandOff && mode != PriceBandFlag && mode != PriceBandReject {
+		return false, newError(CodeValidation, "unknown price band mode %s", mode)
+	}
+	if basisPoints < 0 {
+		return false, newError(CodeValidation, "price band must not be negative")
+	}
+
+	err = _putJSON(ctx, priceBandPolicyKey, PriceBandPolicy{Mode: mode, BasisPoints: basisPoints})
+	if err != nil {
+		return false, wrapError(err, "failed to put state for price band policy")
+	}
+
+	return true, nil
+}
+
+// GetPriceBandPolicy returns the price band policy
+func (c *TokenERC721Contract) GetPriceBandPolicy(ctx kalpsdk.TransactionContextInterface) (*PriceBandPolicy, error) {
+	return _readPriceBandPolicy(ctx)
+}
+
+// _checkPriceBand compares price with the latest appraisal of the NFT. It returns a flag for the inspector
+// if the price is outside the band and the policy flags, and an error if the policy rejects.
+func _checkPriceBand(ctx kalpsdk.TransactionContextInterface, tokenId string, kind string, price int) (string, error) {
+	policy, err := _readPriceBandPolicy(ctx)
+	if err != nil || policy.Mode == PriceBandOff {
+		return "", err
+	}
+
+	appraisals, err := _listJSON[Appraisal](ctx, appraisalPrefix, tokenId)
+	if err != nil || len(appraisals) == 0 {
+		return "", err
+	}
+	latest := appraisals[len(appraisals)-1]
+
+	deviation := latest.Value * policy.BasisPoints / maxBasisPoints
+	if price >= latest.Value-deviation && price <= latest.Value+deviation {
+		return "", nil
+	}
+
+	if policy.Mode == PriceBandReject {
+		return "", newError(CodeValidation, "%s %d is outside the allowed band around the appraised value %d", kind, price, latest.Value).
+			WithDetail("appraisedValue", latest.Value).WithDetail("bandBasisPoints", policy.BasisPoints)
+	}
+
+	return fmt.Sprintf("%s %d is outside %d basis points of the appraised value %d", kind, price, policy.BasisPoints, latest.Value), nil
+}
+
+func _readPriceBandPolicy(ctx kalpsdk.TransactionContextInterface) (*PriceBandPolicy, error) {
+	policy := &PriceBandPolicy{Mode: PriceBandOff}
+	_, err := _getJSON(ctx, priceBandPolicyKey, policy)
+	if err != nil {
+		return nil, wrapError(err, "failed to get price band policy")
+	}
+
+	return policy, nil
+}
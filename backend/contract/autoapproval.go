@@ -0,0 +1,206 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define key name for the auto-approval policy
+const autoApprovalPolicyKey = "autoApprovalPolicy"
+
+// Define objectType name for auto-approval evaluations
+const autoApprovalPrefix = "autoApproval"
+
+// Decider recorded on sale decisions made by the auto-approval policy
+const autoApprover = "auto-approval"
+
+// Rules of the auto-approval policy
+const (
+	RulePriceUnderLimit   = "price_under_limit"
+	RuleSellerKYC         = "seller_kyc"
+	RuleBuyerKYC          = "buyer_kyc"
+	RuleNoLiens           = "no_liens"
+	RuleNoDisputes        = "no_disputes"
+	RuleNoPriorRejections = "no_prior_rejections"
+	RuleNoPriceFlags      = "no_price_flags"
+	RuleSoleOwner         = "sole_owner"
+	RuleBelowAMLThreshold = "below_aml_threshold"
+)
+
+// AutoApprovalPolicy lets low-risk sales skip the inspector's review
+type AutoApprovalPolicy struct {
+	Enabled  bool `json:"enabled"`
+	MaxPrice int  `json:"maxPrice"` // Earnest money must be below this to auto-approve
+}
+
+// RuleResult is the outcome of one rule of the auto-approval policy
+type RuleResult struct {
+	Rule   string `json:"rule"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// AutoApproval records how the auto-approval policy evaluated a buy request
+type AutoApproval struct {
+	TokenId      string        `json:"tokenId"`
+	Buyer        string        `json:"buyer,omitempty"` // Empty for confidential sales
+	Rules        []*RuleResult `json:"rules"`
+	AutoApproved bool          `json:"autoApproved"` // False if the sale was routed to manual review
+	EvaluatedAt  int64         `json:"evaluatedAt"`  // Unix seconds
+	TxId         string        `json:"txId"`
+}
+
+// SetAutoApprovalPolicy allows the admin to have buy requests for less than maxPrice approved without the
+// inspector when both parties passed KYC, the NFT has no liens or disputes and the buyer was never rejected
+func (c *TokenERC721Contract) SetAutoApprovalPolicy(ctx kalpsdk.TransactionContextInterface, enabled bool, maxPrice int) (bool, error) {
+	_, err := c._requireAdmin(ctx, "set the auto-approval policy")
+	if err != nil {
+		return false, err
+	}
+
+	if maxPrice < 0 {
+		return false, newError(CodeValidation, "auto-approval price limit must not be negative")
+	}
+
+	err = _putJSON(ctx, autoApprovalPolicyKey, AutoApprovalPolicy{Enabled: enabled, MaxPrice: maxPrice})
+	if err != nil {
+		return false, wrapError(err, "failed to put state for auto-approval policy")
+	}
+
+	return true, nil
+}
+
+// GetAutoApprovalPolicy returns the auto-approval policy
+func (c *TokenERC721Contract) GetAutoApprovalPolicy(ctx kalpsdk.TransactionContextInterface) (*AutoApprovalPolicy, error) {
+	return _readAutoApprovalPolicy(ctx)
+}
+
+// GetAutoApprovals returns the auto-approval evaluations of the NFT's buy requests, oldest first
+func (c *TokenERC721Contract) GetAutoApprovals(ctx kalpsdk.TransactionContextInterface, tokenId string) ([]*AutoApproval, error) {
+	return _listJSON[AutoApproval](ctx, autoApprovalPrefix, tokenId)
+}
+
+// _runAutoApproval evaluates the auto-approval policy against a new buy request and records which rules
+// fired. It returns true if every rule passed and the sale should be approved without the inspector.
+func _runAutoApproval(ctx kalpsdk.TransactionContextInterface, sale *Sale) (bool, error) {
+	policy, err := _readAutoApprovalPolicy(ctx)
+	if err != nil || !policy.Enabled {
+		return false, err
+	}
+
+	rules, err := _evaluateAutoApprovalRules(ctx, policy, sale)
+	if err != nil {
+		return false, err
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	result := &AutoApproval{
+		TokenId:      sale.TokenId,
+		Buyer:        sale.Buyer,
+		Rules:        rules,
+		AutoApproved: true,
+		EvaluatedAt:  now,
+		TxId:         ctx.GetTxID(),
+	}
+	if sale.Confidential {
+		result.Buyer = ""
+	}
+	for _, rule := range rules {
+		if !rule.Passed {
+			result.AutoApproved = false
+		}
+	}
+
+	resultKey, err := _compositeKey(ctx, autoApprovalPrefix, sale.TokenId, _sortableTime(now), result.TxId)
+	if err != nil {
+		return false, err
+	}
+	err = _putJSON(ctx, resultKey, result)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for auto-approval")
+	}
+
+	// An approved sale emits Transfer instead
+	if !result.AutoApproved {
+		err = _setJSONEvent(ctx, "SaleRoutedToReview", result)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return result.AutoApproved, nil
+}
+
+// _evaluateAutoApprovalRules checks every rule so the record shows all the reasons a sale needs review.
+// Details do not mention amounts, which are private for confidential sales.
+func _evaluateAutoApprovalRules(ctx kalpsdk.TransactionContextInterface, policy *AutoApprovalPolicy, sale *Sale) ([]*RuleResult, error) {
+	var rules []*RuleResult
+	add := func(rule string, passed bool, detail string) {
+		result := &RuleResult{Rule: rule, Passed: passed}
+		if !passed {
+			result.Detail = detail
+		}
+		rules = append(rules, result)
+	}
+
+	add(RulePriceUnderLimit, sale.Earnest < policy.MaxPrice, "earnest money is not under the auto-approval limit")
+	tiers, err := _requiredTiers(ctx, sale)
+	if err != nil {
+		return nil, err
+	}
+	add(RuleBelowAMLThreshold, len(tiers) == 1, "sale needs sign-off above the inspector")
+	add(RuleNoPriceFlags, sale.PriceFlag == "" && sale.EarnestFlag == "", "price is outside the appraisal band")
+
+	for _, party := range []struct{ rule, userId string }{{RuleSellerKYC, sale.Seller}, {RuleBuyerKYC, sale.Buyer}} {
+		status, err := _getKYCStatus(ctx, party.userId)
+		if err != nil {
+			return nil, err
+		}
+		add(party.rule, status.IsEligible, "KYC is missing or revoked")
+	}
+
+	liens, err := _listJSON[Lien](ctx, lienPrefix, sale.TokenId)
+	if err != nil {
+		return nil, err
+	}
+	unreleased := false
+	for _, lien := range liens {
+		unreleased = unreleased || !lien.Released
+	}
+	add(RuleNoLiens, !unreleased, "NFT has an unreleased lien")
+
+	// Any dispute, even a resolved one, makes the NFT not low-risk
+	disputes, err := _listJSON[Dispute](ctx, disputePrefix, sale.TokenId)
+	if err != nil {
+		return nil, err
+	}
+	add(RuleNoDisputes, len(disputes) == 0, "NFT has been disputed")
+
+	rejected, err := _hasRejection(ctx, sale.Buyer)
+	if err != nil {
+		return nil, err
+	}
+	add(RuleNoPriorRejections, !rejected, "buyer has had a sale rejected")
+
+	// The co-owners of a co-owned NFT accept the buy request through ConsentToAction
+	coOwnership, err := _getCoOwnership(ctx, sale.TokenId)
+	if err != nil {
+		return nil, err
+	}
+	add(RuleSoleOwner, coOwnership == nil, "NFT is co-owned")
+
+	return rules, nil
+}
+
+func _readAutoApprovalPolicy(ctx kalpsdk.TransactionContextInterface) (*AutoApprovalPolicy, error) {
+	policy := new(AutoApprovalPolicy)
+	_, err := _getJSON(ctx, autoApprovalPolicyKey, policy)
+	if err != nil {
+		return nil, wrapError(err, "failed to get auto-approval policy")
+	}
+
+	return policy, nil
+}
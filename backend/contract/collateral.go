@@ -0,0 +1,298 @@
+package contract
+
+import (
+	"encoding/json"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for collateral pledges
+const collateralPrefix = "collateral"
+
+// LoanTerms are the terms of the loan secured by a pledged NFT, passed to PledgeCollateral as JSON
+type LoanTerms struct {
+	Principal       int    `json:"principal"`
+	InterestBps     int    `json:"interestBps"`
+	MaturityDate    int64  `json:"maturityDate"`    // Unix seconds the loan is due, before which no default can be declared
+	GracePeriodDays int    `json:"gracePeriodDays"` // Time the borrower has to cure a default before foreclosure
+	DocumentHash    string `json:"documentHash"`
+}
+
+// Pledge locks an NFT as collateral for a loan. The NFT can't be listed or transferred
+// until the lender releases it or forecloses after a default.
+type Pledge struct {
+	TokenId     string    `json:"tokenId"`
+	Borrower    string    `json:"borrower"`
+	Lender      string    `json:"lender"`
+	LoanTerms   LoanTerms `json:"loanTerms"`
+	PledgedAt   int64     `json:"pledgedAt"`             // Unix seconds
+	DefaultedAt int64     `json:"defaultedAt,omitempty"` // Unix seconds, set once the lender declares a default
+}
+
+// PledgeCollateral allows the owner to lock an unlisted NFT as collateral for a loan from lender.
+// The co-owners of a co-owned NFT pledge it through a pledge action.
+func (c *TokenERC721Contract) PledgeCollateral(ctx kalpsdk.TransactionContextInterface, tokenId string, lender string, loanTerms string) (*Pledge, error) {
+	ownerID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get owner identity")
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if nft.Owner != ownerID {
+		return nil, newError(CodeUnauthorized, "only the owner can pledge the NFT")
+	}
+
+	err = _requireSoleOwner(ctx, tokenId, ActionPledge)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := LoanTerms{}
+	err = json.Unmarshal([]byte(loanTerms), &terms)
+	if err != nil {
+		return nil, wrapErrorAs(CodeValidation, err, "failed to unmarshal loan terms")
+	}
+
+	return _pledgeNFT(ctx, nft, lender, terms)
+}
+
+// ReleaseCollateral allows the lender to unlock a pledged NFT, for example once the loan is repaid
+func (c *TokenERC721Contract) ReleaseCollateral(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	pledge, err := _readPledgeAsLender(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	err = _deletePledge(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	err = _setJSONEvent(ctx, "CollateralReleased", pledge)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeclareDefault allows the lender to record that the borrower has defaulted once the loan has matured,
+// which starts the grace period
+func (c *TokenERC721Contract) DeclareDefault(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	pledge, err := _readPledgeAsLender(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+	if pledge.DefaultedAt != 0 {
+		return false, newError(CodeConflict, "a default has already been declared").WithDetail("tokenId", tokenId)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	if now < pledge.LoanTerms.MaturityDate {
+		return false, newError(CodeInvalidState, "the loan has not matured").WithDetail("maturityDate", pledge.LoanTerms.MaturityDate)
+	}
+	pledge.DefaultedAt = now
+
+	err = _putPledge(ctx, pledge)
+	if err != nil {
+		return false, err
+	}
+
+	err = _setJSONEvent(ctx, "DefaultDeclared", pledge)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Foreclose allows the lender to take ownership of the pledged NFT once the grace period after a default has passed
+func (c *TokenERC721Contract) Foreclose(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+		return c.foreclose(ctx, tokenId)
+	})
+}
+
+func (c *TokenERC721Contract) foreclose(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	pledge, err := _readPledgeAsLender(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+	if pledge.DefaultedAt == 0 {
+		return false, newError(CodeInvalidState, "no default has been declared").WithDetail("tokenId", tokenId)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	graceEndsAt := pledge.DefaultedAt + int64(pledge.LoanTerms.GracePeriodDays)*24*60*60
+	if now < graceEndsAt {
+		return false, newError(CodeInvalidState, "the grace period has not ended").WithDetail("graceEndsAt", graceEndsAt)
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	// The pledge is consumed by the foreclosure, which unlocks the transfer to the lender
+	err = _deletePledge(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	err = _transferNFT(ctx, nft, pledge.Lender)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// _requireNotPledged fails if the NFT is locked as collateral
+func _requireNotPledged(ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+	pledge, pledged, err := _getPledge(ctx, tokenId)
+	if err != nil {
+		return err
+	}
+	if pledged {
+		return newError(CodeInvalidState, "NFT %s is pledged as collateral to %s", tokenId, pledge.Lender).WithDetail("tokenId", tokenId)
+	}
+
+	return nil
+}
+
+func _readPledgeAsLender(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Pledge, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get client identity")
+	}
+
+	pledge, pledged, err := _getPledge(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if !pledged {
+		return nil, newError(CodeNotFound, "NFT is not pledged").WithDetail("tokenId", tokenId)
+	}
+	if clientID != pledge.Lender {
+		return nil, newError(CodeUnauthorized, "only the lender can manage the pledge")
+	}
+
+	return pledge, nil
+}
+
+func _getPledge(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Pledge, bool, error) {
+	pledgeKey, err := _compositeKey(ctx, collateralPrefix, tokenId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pledge := new(Pledge)
+	found, err := _getJSON(ctx, pledgeKey, pledge)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	return pledge, true, nil
+}
+
+func _putPledge(ctx kalpsdk.TransactionContextInterface, pledge *Pledge) error {
+	pledgeKey, err := _compositeKey(ctx, collateralPrefix, pledge.TokenId)
+	if err != nil {
+		return err
+	}
+
+	err = _putJSON(ctx, pledgeKey, pledge)
+	if err != nil {
+		return wrapError(err, "failed to put state for pledge")
+	}
+
+	return nil
+}
+
+func _deletePledge(ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+	pledgeKey, err := _compositeKey(ctx, collateralPrefix, tokenId)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.DelStateWithoutKYC(pledgeKey)
+	if err != nil {
+		return wrapError(err, "failed to delete pledge")
+	}
+
+	return nil
+}
+
+// _pledgeNFT locks the NFT as collateral for a loan from lender to its owner
+func _pledgeNFT(ctx kalpsdk.TransactionContextInterface, nft *Nft, lender string, terms LoanTerms) (*Pledge, error) {
+	tokenId, ownerID := nft.TokenId, nft.Owner
+	err := _requireNotPaused(ctx, ScopeTransfer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = _requireNoOpenDispute(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	// A foreclosure would move the NFT away before the seller can have the sale reverted
+	err = _requireDisputeWindowClosed(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	if lender == "" || lender == ownerID {
+		return nil, newError(CodeValidation, "lender must be set and differ from the owner")
+	}
+	if terms.Principal <= 0 || terms.GracePeriodDays <= 0 {
+		return nil, newError(CodeValidation, "loan principal and grace period must be positive")
+	}
+
+	err = _requireNotFrozen(ctx, tokenId, ownerID, lender)
+	if err != nil {
+		return nil, err
+	}
+	err = _requireNotPledged(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	sale, listed, err := _getSale(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if listed && (sale.IsOnSale || sale.IsPendingApproval) {
+		return nil, newError(CodeInvalidState, "a listed NFT can't be pledged").WithDetail("tokenId", tokenId)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if terms.MaturityDate <= now {
+		return nil, newError(CodeValidation, "loan maturity date must be in the future").WithDetail("maturityDate", terms.MaturityDate)
+	}
+
+	pledge := &Pledge{TokenId: tokenId, Borrower: ownerID, Lender: lender, LoanTerms: terms, PledgedAt: now}
+	err = _putPledge(ctx, pledge)
+	if err != nil {
+		return nil, err
+	}
+
+	err = _setJSONEvent(ctx, "CollateralPledged", pledge)
+	if err != nil {
+		return nil, err
+	}
+
+	return pledge, nil
+}
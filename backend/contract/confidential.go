@@ -0,0 +1,298 @@
+package contract
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Private data collection holding the terms of confidential sales, see collections_config.json
+const saleTermsCollection = "saleTermsCollection"
+
+// Transient data key of the terms passed to ListNFTForConfidentialSale and BuyNFTConfidential
+const saleTermsTransientKey = "saleTerms"
+
+// SaleTerms are the sensitive terms of a confidential sale. They are stored in the saleTermsCollection
+// private data collection under the sale key, and the public Sale only keeps their SHA-256 as TermsHash.
+// The salt keeps low-entropy prices from being guessed from the hash.
+type SaleTerms struct {
+	TokenId     string `json:"tokenId"`
+	Price       int    `json:"price"`
+	Earnest     int    `json:"earnest"`
+	Buyer       string `json:"buyer"`
+	PaymentTxId string `json:"paymentTxId,omitempty"`
+	PriceFlag   string `json:"priceFlag,omitempty"`
+	EarnestFlag string `json:"earnestFlag,omitempty"`
+	Salt        string `json:"salt"`
+}
+
+// TermsInput is the transient data passed under the saleTerms key
+type TermsInput struct {
+	Price   int    `json:"price,omitempty"`   // ListNFTForConfidentialSale
+	Earnest int    `json:"earnest,omitempty"` // BuyNFTConfidential
+	Salt    string `json:"salt,omitempty"`    // ListNFTForConfidentialSale
+}
+
+// ListNFTForConfidentialSale allows the owner to list their NFT with a price only the seller, buyer and inspector can read.
+// The price and a random salt are passed as transient data under the saleTerms key, so they never reach the public ledger.
+func (c *TokenERC721Contract) ListNFTForConfidentialSale(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	ownerID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get owner identity")
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return false, wrapError(err, "failed to read NFT")
+	}
+
+	if nft.Owner != ownerID {
+		return false, newError(CodeUnauthorized, "only the owner can list the NFT for sale")
+	}
+
+	err = _requireSoleOwner(ctx, tokenId, ActionList)
+	if err != nil {
+		return false, err
+	}
+
+	input, err := _readTermsInput(ctx)
+	if err != nil {
+		return false, err
+	}
+	if input.Price <= 0 || input.Salt == "" {
+		return false, newError(CodeValidation, "confidential listings need a positive price and a salt")
+	}
+
+	listing := &Sale{Price: input.Price, LeaseSurvivesSale: true, Confidential: true, termsSalt: input.Salt}
+	err = _listNFT(ctx, nft, listing)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// BuyNFTConfidential allows a buyer to request a confidential sale. The earnest money is passed
+// as transient data under the saleTerms key.
+func (c *TokenERC721Contract) BuyNFTConfidential(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	buyerID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get buyer identity")
+	}
+
+	input, err := _readTermsInput(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+		return _placeBuyRequest(ctx, tokenId, buyerID, input.Earnest, "", true)
+	})
+}
+
+// GetSaleTerms returns the private terms of a confidential sale to its seller, its buyer or the inspector
+func (c *TokenERC721Contract) GetSaleTerms(ctx kalpsdk.TransactionContextInterface, tokenId string) (*SaleTerms, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get client identity")
+	}
+
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if !sale.Confidential {
+		return nil, newError(CodeInvalidState, "NFT is not listed for confidential sale").WithDetail("tokenId", tokenId)
+	}
+
+	config, err := c._readConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != sale.Seller && clientID != sale.Buyer && clientID != config.Inspector {
+		return nil, newError(CodeUnauthorized, "only the seller, the buyer or the inspector can read the sale terms")
+	}
+
+	return sale.terms(), nil
+}
+
+// terms returns the sensitive terms of the sale
+func (s *Sale) terms() *SaleTerms {
+	return &SaleTerms{
+		TokenId:     s.TokenId,
+		Price:       s.Price,
+		Earnest:     s.Earnest,
+		Buyer:       s.Buyer,
+		PaymentTxId: s.PaymentTxId,
+		PriceFlag:   s.PriceFlag,
+		EarnestFlag: s.EarnestFlag,
+		Salt:        s.termsSalt,
+	}
+}
+
+// termsHash returns the hex SHA-256 of the sale terms. It is the TermsHash stored with the sale,
+// which co-owners sign when they accept a buy request.
+func (s *Sale) termsHash() (string, error) {
+	termsBytes, err := json.Marshal(s.terms())
+	if err != nil {
+		return "", wrapError(err, "failed to marshal sale terms")
+	}
+
+	hash := sha256.Sum256(termsBytes)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// _hashUserId returns the hex HMAC-SHA256 of a user id keyed by the salt of the sale terms, which stands in
+// for the buyer on the public records of confidential sales. Without the salt it can't be matched to a user id.
+func _hashUserId(userID string, salt string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// _putSaleTerms writes the terms of a confidential sale to the private data collection and
+// returns the public copy of the sale, which keeps only their hash
+func _putSaleTerms(ctx kalpsdk.TransactionContextInterface, saleKey string, sale *Sale) (*Sale, error) {
+	stub, err := _stub(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	termsBytes, err := json.Marshal(sale.terms())
+	if err != nil {
+		return nil, wrapError(err, "failed to marshal sale terms")
+	}
+	err = stub.PutPrivateData(saleTermsCollection, saleKey, termsBytes)
+	if err != nil {
+		return nil, wrapError(err, "failed to put private data for sale terms")
+	}
+
+	// The hash matches the private data hash every channel member can read
+	termsHash, err := sale.termsHash()
+	if err != nil {
+		return nil, err
+	}
+	public := *sale
+	public.Price = 0
+	public.Earnest = 0
+	public.Buyer = ""
+	public.PaymentTxId = ""
+	public.PriceFlag = ""
+	public.EarnestFlag = ""
+	public.TermsHash = termsHash
+
+	return &public, nil
+}
+
+// _loadSaleTerms fills in the terms of a confidential sale from the private data collection
+func _loadSaleTerms(ctx kalpsdk.TransactionContextInterface, saleKey string, sale *Sale) error {
+	terms := new(SaleTerms)
+	found, err := _getPrivateCopy(ctx, saleKey, terms)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return newError(CodeInternal, "sale terms are missing from the private data collection").WithDetail("tokenId", sale.TokenId)
+	}
+
+	sale.Price = terms.Price
+	sale.Earnest = terms.Earnest
+	sale.Buyer = terms.Buyer
+	sale.PaymentTxId = terms.PaymentTxId
+	sale.PriceFlag = terms.PriceFlag
+	sale.EarnestFlag = terms.EarnestFlag
+	sale.termsSalt = terms.Salt
+
+	return nil
+}
+
+func _readTermsInput(ctx kalpsdk.TransactionContextInterface) (*TermsInput, error) {
+	stub, err := _stub(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, wrapError(err, "failed to get transient data")
+	}
+	inputBytes, ok := transient[saleTermsTransientKey]
+	if !ok {
+		return nil, newError(CodeValidation, "sale terms must be passed as transient data under %s", saleTermsTransientKey)
+	}
+
+	input := new(TermsInput)
+	err = json.Unmarshal(inputBytes, input)
+	if err != nil {
+		return nil, wrapErrorAs(CodeValidation, err, "failed to unmarshal transient sale terms")
+	}
+
+	return input, nil
+}
+
+// stubProvider is implemented by kalpsdk.TransactionContext, whose interface does not expose the stub
+type stubProvider interface {
+	GetStub() shim.ChaincodeStubInterface
+}
+
+// _stub returns the shim stub behind the transaction context, for private and transient data.
+// Private data bypasses the state cache; like GetState, GetPrivateData only sees committed values.
+func _stub(ctx kalpsdk.TransactionContextInterface) (shim.ChaincodeStubInterface, error) {
+	if cache, ok := ctx.(*stateCache); ok {
+		ctx = cache.TransactionContextInterface
+	}
+
+	provider, ok := ctx.(stubProvider)
+	if !ok {
+		return nil, newError(CodeInternal, "transaction context does not expose a chaincode stub")
+	}
+
+	return provider.GetStub(), nil
+}
+
+// _putPrivateCopy stores the full version of a public record whose amounts are confidential
+func _putPrivateCopy(ctx kalpsdk.TransactionContextInterface, key string, v interface{}) error {
+	stub, err := _stub(ctx)
+	if err != nil {
+		return err
+	}
+
+	valueBytes, err := json.Marshal(v)
+	if err != nil {
+		return wrapError(err, "failed to marshal private data for key %s", key)
+	}
+
+	err = stub.PutPrivateData(saleTermsCollection, key, valueBytes)
+	if err != nil {
+		return wrapError(err, "failed to put private data for key %s", key)
+	}
+
+	return nil
+}
+
+// _getPrivateCopy reads the full version of a public record whose amounts are confidential
+func _getPrivateCopy(ctx kalpsdk.TransactionContextInterface, key string, v interface{}) (bool, error) {
+	stub, err := _stub(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	valueBytes, err := stub.GetPrivateData(saleTermsCollection, key)
+	if err != nil {
+		return false, wrapError(err, "failed to get private data for key %s", key)
+	}
+	if len(valueBytes) == 0 {
+		return false, nil
+	}
+
+	err = json.Unmarshal(valueBytes, v)
+	if err != nil {
+		return false, wrapError(err, "failed to unmarshal private data for key %s", key)
+	}
+
+	return true, nil
+}
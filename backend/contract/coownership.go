@@ -0,0 +1,515 @@
+package contract
+
+import (
+	"encoding/json"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType names for co-ownerships and the actions awaiting co-owner consent
+const coOwnershipPrefix = "coOwnership"
+const consentActionPrefix = "consentAction"
+
+// Consent policies of a co-owned NFT
+const (
+	ConsentUnanimous = "unanimous" // Every co-owner signs
+	ConsentMajority  = "majority"  // Co-owners holding more than half of the shares sign
+)
+
+// Actions on a co-owned NFT that need co-owner consent
+const (
+	ActionList        = "list"        // List the NFT for sale
+	ActionChangePrice = "changePrice" // Change the asking price of a listing
+	ActionAcceptSale  = "acceptSale"  // Accept the pending buy request on the sellers' side
+	ActionSetCoOwners = "setCoOwners" // Change the co-owners, their shares or the consent policy
+	ActionPledge      = "pledge"      // Pledge the NFT as collateral for a loan
+	ActionLease       = "lease"       // Lease the NFT to a tenant, or end the current lease
+)
+
+// CoOwner is one owner of a co-owned NFT and their share in basis points
+type CoOwner struct {
+	UserId      string `json:"userId"`
+	BasisPoints int    `json:"basisPoints"`
+}
+
+// CoOwnership lists the owners of an NFT held jointly. Nft.Owner stays the managing owner,
+// who holds the NFT on the ledger; the shares add up to 10000 basis points.
+type CoOwnership struct {
+	TokenId  string     `json:"tokenId"`
+	CoOwners []*CoOwner `json:"coOwners"`
+	Policy   string     `json:"policy"` // unanimous or majority
+}
+
+// ActionParams are the parameters of a proposed action. Only the ones of the action type are used.
+type ActionParams struct {
+	Price          int            `json:"price,omitempty"`          // list and changePrice
+	EndLeaseOnSale bool           `json:"endLeaseOnSale,omitempty"` // list, the lease survives the sale unless set
+	Allowlist      *SaleAllowlist `json:"allowlist,omitempty"`      // list, makes the listing private by Merkle root
+	TermsHash      string         `json:"termsHash,omitempty"`      // acceptSale, the TermsHash of the sale with the buy request
+	RequestedAt    int64          `json:"requestedAt,omitempty"`    // acceptSale, binds the acceptance to one buy request
+	CoOwners       []*CoOwner     `json:"coOwners,omitempty"`       // setCoOwners
+	Policy         string         `json:"policy,omitempty"`         // setCoOwners
+	Lender         string         `json:"lender,omitempty"`         // pledge
+	LoanTerms      *LoanTerms     `json:"loanTerms,omitempty"`      // pledge
+	Tenant         string         `json:"tenant,omitempty"`         // lease, an empty tenant ends the current lease
+	Expires        int64          `json:"expires,omitempty"`        // lease
+}
+
+// ConsentAction is an action on a co-owned NFT collecting co-owner signatures.
+// It is executed in the transaction that brings in the last signature needed.
+type ConsentAction struct {
+	ActionId   string       `json:"actionId"` // Id of the transaction that proposed the action
+	TokenId    string       `json:"tokenId"`
+	ActionType string       `json:"actionType"`
+	Params     ActionParams `json:"params"`
+	ProposedBy string       `json:"proposedBy"`
+	ProposedAt int64        `json:"proposedAt"` // Unix seconds
+	Consents   []string     `json:"consents"`
+	Executed   bool         `json:"executed"`
+	ExecutedAt int64        `json:"executedAt,omitempty"`
+}
+
+// SetCoOwners allows the owner of an NFT held alone to make it co-owned. The owner must be one of the co-owners.
+// Once the NFT is co-owned, the co-owners change through a setCoOwners action.
+func (c *TokenERC721Contract) SetCoOwners(ctx kalpsdk.TransactionContextInterface, tokenId string, coOwnersJSON string, policy string) (*CoOwnership, error) {
+	ownerID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get owner identity")
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if nft.Owner != ownerID {
+		return nil, newError(CodeUnauthorized, "only the owner can set the co-owners")
+	}
+
+	err = _requireSoleOwner(ctx, tokenId, ActionSetCoOwners)
+	if err != nil {
+		return nil, err
+	}
+
+	var coOwners []*CoOwner
+	err = json.Unmarshal([]byte(coOwnersJSON), &coOwners)
+	if err != nil {
+		return nil, wrapErrorAs(CodeValidation, err, "failed to unmarshal co-owners")
+	}
+
+	coOwnership := &CoOwnership{TokenId: tokenId, CoOwners: coOwners, Policy: policy}
+	err = _putCoOwnership(ctx, coOwnership, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return coOwnership, nil
+}
+
+// GetCoOwnership returns the co-owners of an NFT, or nil if it is held by a single owner
+func (c *TokenERC721Contract) GetCoOwnership(ctx kalpsdk.TransactionContextInterface, tokenId string) (*CoOwnership, error) {
+	return _getCoOwnership(ctx, tokenId)
+}
+
+// ProposeAction allows a co-owner to propose an action on a co-owned NFT. The proposal counts as the
+// proposer's consent. paramsJSON holds the ActionParams of the action type.
+func (c *TokenERC721Contract) ProposeAction(ctx kalpsdk.TransactionContextInterface, tokenId string, actionType string, paramsJSON string) (*ConsentAction, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (*ConsentAction, error) {
+		return c.proposeAction(ctx, tokenId, actionType, paramsJSON)
+	})
+}
+
+func (c *TokenERC721Contract) proposeAction(ctx kalpsdk.TransactionContextInterface, tokenId string, actionType string, paramsJSON string) (*ConsentAction, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get client identity")
+	}
+
+	coOwnership, err := _readCoOwnership(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if coOwnership.shareOf(clientID) == 0 {
+		return nil, newError(CodeUnauthorized, "only a co-owner can propose an action")
+	}
+
+	var params ActionParams
+	err = json.Unmarshal([]byte(paramsJSON), &params)
+	if err != nil {
+		return nil, wrapErrorAs(CodeValidation, err, "failed to unmarshal action params")
+	}
+
+	switch actionType {
+	case ActionList, ActionChangePrice:
+		if params.Price <= 0 {
+			return nil, newError(CodeValidation, "price must be positive")
+		}
+		// Action params are public, so a proposed private listing names its buyers by Merkle root only
+		if params.Allowlist != nil && len(params.Allowlist.Buyers) > 0 {
+			return nil, newError(CodeValidation, "a proposed private listing must restrict buyers by Merkle root")
+		}
+	case ActionAcceptSale:
+		if params.TermsHash == "" || params.RequestedAt <= 0 {
+			return nil, newError(CodeValidation, "terms hash and request time must be set")
+		}
+	case ActionSetCoOwners:
+		err = _validateCoOwnership(&CoOwnership{TokenId: tokenId, CoOwners: params.CoOwners, Policy: params.Policy})
+		if err != nil {
+			return nil, err
+		}
+	case ActionPledge:
+		if params.Lender == "" || params.LoanTerms == nil {
+			return nil, newError(CodeValidation, "lender and loan terms must be set")
+		}
+	case ActionLease:
+	default:
+		return nil, newError(CodeValidation, "unknown action type %s", actionType).WithDetail("actionType", actionType)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	action := &ConsentAction{
+		ActionId:   ctx.GetTxID(),
+		TokenId:    tokenId,
+		ActionType: actionType,
+		Params:     params,
+		ProposedBy: clientID,
+		ProposedAt: now,
+		Consents:   []string{clientID},
+	}
+
+	err = _setJSONEvent(ctx, "ActionProposed", action)
+	if err != nil {
+		return nil, err
+	}
+
+	err = _executeIfConsented(ctx, coOwnership, action, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return action, nil
+}
+
+// ConsentToAction allows a co-owner to sign a proposed action. The action is executed once the
+// signatures meet the consent policy; signatures of users who are no longer co-owners don't count.
+func (c *TokenERC721Contract) ConsentToAction(ctx kalpsdk.TransactionContextInterface, actionId string) (*ConsentAction, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (*ConsentAction, error) {
+		return c.consentToAction(ctx, actionId)
+	})
+}
+
+func (c *TokenERC721Contract) consentToAction(ctx kalpsdk.TransactionContextInterface, actionId string) (*ConsentAction, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get client identity")
+	}
+
+	action, err := _readConsentAction(ctx, actionId)
+	if err != nil {
+		return nil, err
+	}
+	if action.Executed {
+		return nil, newError(CodeInvalidState, "action has already been executed").WithDetail("actionId", actionId)
+	}
+
+	coOwnership, err := _readCoOwnership(ctx, action.TokenId)
+	if err != nil {
+		return nil, err
+	}
+	if coOwnership.shareOf(clientID) == 0 {
+		return nil, newError(CodeUnauthorized, "only a co-owner can consent to an action")
+	}
+	for _, signer := range action.Consents {
+		if signer == clientID {
+			return nil, newError(CodeConflict, "co-owner has already consented").WithDetail("actionId", actionId)
+		}
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	action.Consents = append(action.Consents, clientID)
+	err = _setJSONEvent(ctx, "ActionConsented", action)
+	if err != nil {
+		return nil, err
+	}
+
+	err = _executeIfConsented(ctx, coOwnership, action, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return action, nil
+}
+
+// GetConsentAction returns a proposed action and the signatures collected so far
+func (c *TokenERC721Contract) GetConsentAction(ctx kalpsdk.TransactionContextInterface, actionId string) (*ConsentAction, error) {
+	return _readConsentAction(ctx, actionId)
+}
+
+// _executeIfConsented executes the action if its signatures meet the consent policy, then stores it.
+// Fabric keeps only one event per transaction, so ActionExecuted is only emitted by actions without an event of their own.
+func _executeIfConsented(ctx kalpsdk.TransactionContextInterface, coOwnership *CoOwnership, action *ConsentAction, now int64) error {
+	if coOwnership.consented(action.Consents) {
+		emitted, err := _executeAction(ctx, action)
+		if err != nil {
+			return err
+		}
+		action.Executed = true
+		action.ExecutedAt = now
+
+		if !emitted {
+			err = _setJSONEvent(ctx, "ActionExecuted", action)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return _putConsentAction(ctx, action)
+}
+
+// _executeAction carries out the action and reports whether it emitted an event of its own
+func _executeAction(ctx kalpsdk.TransactionContextInterface, action *ConsentAction) (bool, error) {
+	nft, err := _readNFT(ctx, action.TokenId)
+	if err != nil {
+		return false, err
+	}
+
+	switch action.ActionType {
+	case ActionList:
+		listing := &Sale{Price: action.Params.Price, LeaseSurvivesSale: !action.Params.EndLeaseOnSale, Allowlist: action.Params.Allowlist}
+		return false, _listNFT(ctx, nft, listing)
+
+	case ActionChangePrice:
+		sale, err := _readSale(ctx, action.TokenId)
+		if err != nil {
+			return false, err
+		}
+		if !sale.IsOnSale || sale.IsPendingApproval {
+			return false, newError(CodeInvalidState, "only the price of a listing without a pending buy request can change").WithDetail("tokenId", action.TokenId)
+		}
+		priceFlag, err := _checkPriceBand(ctx, action.TokenId, "asking price", action.Params.Price)
+		if err != nil {
+			return false, err
+		}
+		sale.Price = action.Params.Price
+		sale.PriceFlag = priceFlag
+		err = _putSale(ctx, sale)
+		if err != nil {
+			return false, wrapError(err, "failed to update sale state")
+		}
+		return false, nil
+
+	case ActionAcceptSale:
+		sale, err := _readSale(ctx, action.TokenId)
+		if err != nil {
+			return false, err
+		}
+		termsHash, err := sale.termsHash()
+		if err != nil {
+			return false, err
+		}
+		// An acceptance does not carry over to a later request or to other terms
+		if !sale.IsPendingApproval || termsHash != action.Params.TermsHash || sale.RequestedAt != action.Params.RequestedAt {
+			return false, newError(CodeInvalidState, "NFT has no pending buy request with terms hash %s made at %d", action.Params.TermsHash, action.Params.RequestedAt).
+				WithDetail("tokenId", action.TokenId)
+		}
+		sale.SellerAccepted = true
+		err = _putSale(ctx, sale)
+		if err != nil {
+			return false, wrapError(err, "failed to update sale state")
+		}
+		return false, nil
+
+	case ActionSetCoOwners:
+		coOwnership := &CoOwnership{TokenId: action.TokenId, CoOwners: action.Params.CoOwners, Policy: action.Params.Policy}
+		return false, _putCoOwnership(ctx, coOwnership, nft.Owner)
+
+	case ActionPledge:
+		_, err = _pledgeNFT(ctx, nft, action.Params.Lender, *action.Params.LoanTerms)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+
+	case ActionLease:
+		return _setUser(ctx, nft, action.Params.Tenant, action.Params.Expires)
+	}
+
+	return false, newError(CodeValidation, "unknown action type %s", action.ActionType).WithDetail("actionType", action.ActionType)
+}
+
+// _requireSoleOwner fails if the NFT is co-owned, in which case the action needs co-owner consent
+func _requireSoleOwner(ctx kalpsdk.TransactionContextInterface, tokenId string, actionType string) error {
+	coOwnership, err := _getCoOwnership(ctx, tokenId)
+	if err != nil {
+		return err
+	}
+	if coOwnership != nil {
+		return newError(CodeInvalidState, "NFT %s is co-owned, propose a %s action for co-owner consent", tokenId, actionType).
+			WithDetail("tokenId", tokenId).WithDetail("actionType", actionType)
+	}
+
+	return nil
+}
+
+// shareOf returns the share of a user in basis points, or zero if they are not a co-owner
+func (o *CoOwnership) shareOf(userID string) int {
+	for _, coOwner := range o.CoOwners {
+		if coOwner.UserId == userID {
+			return coOwner.BasisPoints
+		}
+	}
+	return 0
+}
+
+// consented reports whether the signatures of the current co-owners meet the consent policy
+func (o *CoOwnership) consented(signers []string) bool {
+	signed, shares := 0, 0
+	for _, signer := range signers {
+		if share := o.shareOf(signer); share > 0 {
+			signed++
+			shares += share
+		}
+	}
+
+	if o.Policy == ConsentUnanimous {
+		return signed == len(o.CoOwners)
+	}
+	return shares*2 > maxBasisPoints
+}
+
+func _validateCoOwnership(coOwnership *CoOwnership) error {
+	if coOwnership.Policy != ConsentUnanimous && coOwnership.Policy != ConsentMajority {
+		return newError(CodeValidation, "unknown consent policy %s", coOwnership.Policy).WithDetail("policy", coOwnership.Policy)
+	}
+	if len(coOwnership.CoOwners) < 2 {
+		return newError(CodeValidation, "a co-owned NFT needs at least two co-owners")
+	}
+
+	total := 0
+	seen := make(map[string]bool)
+	for _, coOwner := range coOwnership.CoOwners {
+		if coOwner == nil || coOwner.UserId == "" || coOwner.BasisPoints <= 0 {
+			return newError(CodeValidation, "every co-owner needs a user id and a positive share")
+		}
+		if seen[coOwner.UserId] {
+			return newError(CodeValidation, "duplicate co-owner %s", coOwner.UserId).WithDetail("userId", coOwner.UserId)
+		}
+		seen[coOwner.UserId] = true
+		total += coOwner.BasisPoints
+	}
+	if total != maxBasisPoints {
+		return newError(CodeValidation, "co-owner shares must add up to %d basis points", maxBasisPoints).WithDetail("total", total)
+	}
+
+	return nil
+}
+
+// _putCoOwnership validates and stores the co-owners. The managing owner must stay one of them.
+func _putCoOwnership(ctx kalpsdk.TransactionContextInterface, coOwnership *CoOwnership, ownerID string) error {
+	err := _validateCoOwnership(coOwnership)
+	if err != nil {
+		return err
+	}
+	if coOwnership.shareOf(ownerID) == 0 {
+		return newError(CodeValidation, "the owner must be one of the co-owners").WithDetail("owner", ownerID)
+	}
+
+	coOwnershipKey, err := _compositeKey(ctx, coOwnershipPrefix, coOwnership.TokenId)
+	if err != nil {
+		return err
+	}
+
+	err = _putJSON(ctx, coOwnershipKey, coOwnership)
+	if err != nil {
+		return wrapError(err, "failed to put state for co-ownership")
+	}
+
+	return nil
+}
+
+func _getCoOwnership(ctx kalpsdk.TransactionContextInterface, tokenId string) (*CoOwnership, error) {
+	coOwnershipKey, err := _compositeKey(ctx, coOwnershipPrefix, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	coOwnership := new(CoOwnership)
+	found, err := _getJSON(ctx, coOwnershipKey, coOwnership)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return coOwnership, nil
+}
+
+func _readCoOwnership(ctx kalpsdk.TransactionContextInterface, tokenId string) (*CoOwnership, error) {
+	coOwnership, err := _getCoOwnership(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if coOwnership == nil {
+		return nil, newError(CodeInvalidState, "NFT is not co-owned").WithDetail("tokenId", tokenId)
+	}
+
+	return coOwnership, nil
+}
+
+// _endCoOwnership removes the co-owners of an NFT, if any
+func _endCoOwnership(ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+	coOwnershipKey, err := _compositeKey(ctx, coOwnershipPrefix, tokenId)
+	if err != nil {
+		return err
+	}
+
+	exists, err := _keyExists(ctx, coOwnershipKey)
+	if err != nil || !exists {
+		return err
+	}
+
+	err = ctx.DelStateWithoutKYC(coOwnershipKey)
+	if err != nil {
+		return wrapError(err, "failed to delete co-ownership")
+	}
+
+	return nil
+}
+
+func _readConsentAction(ctx kalpsdk.TransactionContextInterface, actionId string) (*ConsentAction, error) {
+	actionKey, err := _compositeKey(ctx, consentActionPrefix, actionId)
+	if err != nil {
+		return nil, err
+	}
+
+	action := new(ConsentAction)
+	found, err := _getJSON(ctx, actionKey, action)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, newError(CodeNotFound, "action not found").WithDetail("actionId", actionId)
+	}
+
+	return action, nil
+}
+
+func _putConsentAction(ctx kalpsdk.TransactionContextInterface, action *ConsentAction) error {
+	actionKey, err := _compositeKey(ctx, consentActionPrefix, action.ActionId)
+	if err != nil {
+		return err
+	}
+
+	err = _putJSON(ctx, actionKey, action)
+	if err != nil {
+		return wrapError(err, "failed to put state for consent action")
+	}
+
+	return nil
+}
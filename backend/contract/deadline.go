@@ -0,0 +1,409 @@
+package contract
+
+import (
+	"strings"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define key name for the review period of buy requests
+const reviewPeriodKey = "reviewPeriod"
+
+// Define objectType name for earnest money owed back to buyers of expired requests
+const earnestRefundPrefix = "earnestRefund"
+
+// Review period used until the admin configures one
+const defaultReviewPeriodHours = 72
+
+// Define key prefix of the index of pending buy requests by review deadline. The index uses simple
+// keys so that ExpirePendingSales can scan it by range.
+const reviewDeadlineIndexPrefix = "reviewDeadline/"
+
+// Maximum number of sales ExpirePendingSales scans in one transaction
+const maxExpiryBatchSize = 200
+
+// Reasons earnest money is owed back to a buyer
+const (
+	RefundReasonExpired = "expired"
+	RefundReasonDispute = "dispute"
+)
+
+// EarnestRefund records earnest money owed back to the buyer of an expired or disputed buy request.
+// The contract holds no escrow, so the refund is paid off-chain once the buyer reclaims it.
+type EarnestRefund struct {
+	TokenId     string `json:"tokenId"`
+	Buyer       string `json:"buyer"` // Empty on the public record of a confidential sale
+	Amount      int    `json:"amount"`
+	PaymentTxId string `json:"paymentTxId,omitempty"`
+	ExpiredAt   int64  `json:"expiredAt"` // Unix seconds the request was expired or the dispute resolved
+	TxId        string `json:"txId"`
+	Reason      string `json:"reason"` // expired or dispute
+	Reclaimed   bool   `json:"reclaimed"`
+
+	// The buyer and amount of a confidential sale are only kept in the private copy of the record,
+	// which is keyed by the hash of the buyer keyed with the salt of the sale terms
+	Confidential bool   `json:"confidential,omitempty"`
+	BuyerHash    string `json:"buyerHash,omitempty"`
+	termsSalt    string // Salt the buyer hash is keyed with, never stored
+}
+
+// SetReviewPeriod allows the admin to set how many hours the inspector has to review a buy request
+func (c *TokenERC721Contract) SetReviewPeriod(ctx kalpsdk.TransactionContextInterface, hours int) (bool, error) {
+	_, err := c._requireAdmin(ctx, "set the review period")
+	if err != nil {
+		return false, err
+	}
+
+	if hours <= 0 {
+		return false, newError(CodeValidation, "review period must be positive")
+	}
+
+	err = _putJSON(ctx, reviewPeriodKey, hours)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for review period")
+	}
+
+	return true, nil
+}
+
+// ExpiryProgress reports the outcome of one ExpirePendingSales batch.
+// Bookmark is empty once every request past its deadline has been scanned.
+type ExpiryProgress struct {
+	Scanned  int    `json:"scanned"`
+	Expired  int    `json:"expired"`
+	Bookmark string `json:"bookmark"`
+}
+
+// ExpirePendingSales returns up to batchSize scanned buy requests past their review deadline to the
+// listed state. Anyone can call it. Call it again with the returned bookmark until the bookmark is empty.
+// Requests are found through the review deadline index, so each batch starts where the previous one stopped.
+func (c *TokenERC721Contract) ExpirePendingSales(ctx kalpsdk.TransactionContextInterface, batchSize int, bookmark string) (*ExpiryProgress, error) {
+	if batchSize <= 0 || batchSize > maxExpiryBatchSize {
+		return nil, newError(CodeValidation, "batch size must be between 1 and %d", maxExpiryBatchSize)
+	}
+	if bookmark != "" && !strings.HasPrefix(bookmark, reviewDeadlineIndexPrefix) {
+		return nil, newError(CodeValidation, "invalid bookmark").WithDetail("bookmark", bookmark)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	startKey := reviewDeadlineIndexPrefix
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	iterator, err := ctx.GetStateByRange(startKey, reviewDeadlineIndexPrefix+_sortableTime(now+1))
+	if err != nil {
+		return nil, wrapError(err, "failed to get state by range for %s", reviewDeadlineIndexPrefix)
+	}
+	defer iterator.Close()
+
+	progress := &ExpiryProgress{}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, wrapError(err, "failed to get next %s", reviewDeadlineIndexPrefix)
+		}
+		if queryResponse.Key == bookmark {
+			continue
+		}
+		if progress.Scanned == batchSize {
+			progress.Bookmark = bookmark
+			break
+		}
+
+		progress.Scanned++
+		bookmark = queryResponse.Key
+
+		sale, found, err := _getSale(ctx, string(queryResponse.Value))
+		if err != nil {
+			return nil, err
+		}
+
+		// Entries of requests that were decided, withdrawn or given a new deadline are dropped
+		if !found || _reviewDeadlineKey(sale) != queryResponse.Key {
+			err = ctx.DelStateWithoutKYC(queryResponse.Key)
+			if err != nil {
+				return nil, wrapError(err, "failed to delete state for key %s", queryResponse.Key)
+			}
+			continue
+		}
+
+		// A disputed request is settled by the arbitrator
+		dispute, err := _getOpenDispute(ctx, sale.TokenId)
+		if err != nil {
+			return nil, err
+		}
+		if dispute != nil {
+			continue
+		}
+
+		_, err = _expireBuyRequest(ctx, sale)
+		if err != nil {
+			return nil, err
+		}
+		progress.Expired++
+	}
+
+	return progress, nil
+}
+
+// ReclaimEarnest allows a buyer to take back the earnest money of a buy request whose review deadline has passed
+func (c *TokenERC721Contract) ReclaimEarnest(ctx kalpsdk.TransactionContextInterface, tokenId string) (int, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (int, error) {
+		return c.reclaimEarnest(ctx, tokenId)
+	})
+}
+
+func (c *TokenERC721Contract) reclaimEarnest(ctx kalpsdk.TransactionContextInterface, tokenId string) (int, error) {
+	buyerID, err := ctx.GetUserID()
+	if err != nil {
+		return 0, wrapError(err, "failed to get buyer identity")
+	}
+
+	// Expire the buyer's own request if nobody has called ExpirePendingSales yet
+	var expiredNow *EarnestRefund
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return 0, err
+	}
+	if sale.IsPendingApproval && sale.Buyer == buyerID {
+		expired, err := _reviewExpired(ctx, sale)
+		if err != nil {
+			return 0, err
+		}
+		if !expired {
+			return 0, newError(CodeInvalidState, "the review deadline has not passed").WithDetail("reviewDeadline", sale.ReviewDeadline)
+		}
+		err = _requireNoOpenDispute(ctx, tokenId)
+		if err != nil {
+			return 0, err
+		}
+		expiredNow, err = _expireBuyRequest(ctx, sale)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// The buyer hash of a confidential refund can't be recomputed without the salt of the terms it
+	// was made under, so the refunds of the token are matched to the buyer through their private copies
+	refunds, err := _listJSON[EarnestRefund](ctx, earnestRefundPrefix, tokenId)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	var reclaimed []*EarnestRefund
+	for _, refund := range refunds {
+		if refund.Reclaimed {
+			continue
+		}
+		if refund.Confidential {
+			// Private data written by this transaction can't be read back yet
+			if expiredNow != nil && refund.TxId == expiredNow.TxId {
+				refund.Buyer = expiredNow.Buyer
+				refund.Amount = expiredNow.Amount
+				refund.PaymentTxId = expiredNow.PaymentTxId
+			} else {
+				err = _loadRefundAmount(ctx, refund)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		if refund.Buyer != buyerID {
+			continue
+		}
+		total += refund.Amount
+
+		refund.Reclaimed = true
+		public, err := _putEarnestRefund(ctx, refund)
+		if err != nil {
+			return 0, err
+		}
+		reclaimed = append(reclaimed, public)
+	}
+	if len(reclaimed) == 0 {
+		return 0, newError(CodeNotFound, "no earnest money to reclaim").WithDetail("tokenId", tokenId)
+	}
+
+	err = _setJSONEvent(ctx, "EarnestReclaimed", reclaimed)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// _reviewExpired reports whether the sale has a buy request past its review deadline.
+// Requests made before deadlines were introduced never expire.
+func _reviewExpired(ctx kalpsdk.TransactionContextInterface, sale *Sale) (bool, error) {
+	if !sale.IsPendingApproval || sale.ReviewDeadline == 0 {
+		return false, nil
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return now >= sale.ReviewDeadline, nil
+}
+
+// _expireBuyRequest relists the NFT and records the earnest money owed back to the buyer
+func _expireBuyRequest(ctx kalpsdk.TransactionContextInterface, sale *Sale) (*EarnestRefund, error) {
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refund := &EarnestRefund{
+		TokenId:      sale.TokenId,
+		Buyer:        sale.Buyer,
+		Amount:       sale.Earnest,
+		PaymentTxId:  sale.PaymentTxId,
+		ExpiredAt:    now,
+		TxId:         ctx.GetTxID(),
+		Reason:       RefundReasonExpired,
+		Confidential: sale.Confidential,
+		termsSalt:    sale.termsSalt,
+	}
+	_, err = _putEarnestRefund(ctx, refund)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadlineKey := _reviewDeadlineKey(sale); deadlineKey != "" {
+		err = ctx.DelStateWithoutKYC(deadlineKey)
+		if err != nil {
+			return nil, wrapError(err, "failed to delete state for key %s", deadlineKey)
+		}
+	}
+
+	sale.clearBuyRequest()
+	err = _putSale(ctx, sale)
+	if err != nil {
+		return nil, wrapError(err, "failed to update sale state")
+	}
+
+	return refund, nil
+}
+
+// clearBuyRequest returns the sale to the listed state without a buyer
+func (s *Sale) clearBuyRequest() {
+	s.Earnest = 0
+	s.Buyer = ""
+	s.PaymentTxId = ""
+	s.EarnestFlag = ""
+	s.RequestedAt = 0
+	s.ReviewDeadline = 0
+	s.Conditions = nil
+	s.SellerAccepted = false
+	s.SignOffs = nil
+	s.AwaitingTier = ""
+	s.CompletedAt = 0
+	s.SettlementTxId = ""
+	s.IsApproved = ""
+	s.IsOnSale = true
+	s.IsPendingApproval = false // Reset pending approval
+}
+
+// _reviewDeadlineKey returns the review deadline index key of the sale's buy request, or an empty
+// string if the request has no deadline
+func _reviewDeadlineKey(sale *Sale) string {
+	if !sale.IsPendingApproval || sale.ReviewDeadline == 0 {
+		return ""
+	}
+
+	return reviewDeadlineIndexPrefix + _sortableTime(sale.ReviewDeadline) + "/" + sale.TokenId
+}
+
+// _indexReviewDeadline adds the sale's buy request to the review deadline index. Entries that no
+// longer match their sale are dropped by ExpirePendingSales.
+func _indexReviewDeadline(ctx kalpsdk.TransactionContextInterface, sale *Sale) error {
+	deadlineKey := _reviewDeadlineKey(sale)
+	if deadlineKey == "" {
+		return nil
+	}
+
+	err := ctx.PutStateWithoutKYC(deadlineKey, []byte(sale.TokenId))
+	if err != nil {
+		return wrapError(err, "failed to put state for key %s", deadlineKey)
+	}
+	return nil
+}
+
+func _readReviewPeriod(ctx kalpsdk.TransactionContextInterface) (int64, error) {
+	hours := defaultReviewPeriodHours
+	_, err := _getJSON(ctx, reviewPeriodKey, &hours)
+	if err != nil {
+		return 0, wrapError(err, "failed to get review period")
+	}
+
+	return int64(hours) * 60 * 60, nil
+}
+
+// _putEarnestRefund stores the refund and returns its public record. The buyer and amount of a
+// confidential sale go to the private data collection only.
+func _putEarnestRefund(ctx kalpsdk.TransactionContextInterface, refund *EarnestRefund) (*EarnestRefund, error) {
+	if refund.Confidential && refund.BuyerHash == "" {
+		refund.BuyerHash = _hashUserId(refund.Buyer, refund.termsSalt)
+	}
+	refundKey, err := _refundKey(ctx, refund)
+	if err != nil {
+		return nil, err
+	}
+
+	public := refund
+	if refund.Confidential {
+		err = _putPrivateCopy(ctx, refundKey, refund)
+		if err != nil {
+			return nil, err
+		}
+		redacted := *refund
+		redacted.Buyer = ""
+		redacted.Amount = 0
+		redacted.PaymentTxId = ""
+		public = &redacted
+	}
+
+	err = _putJSON(ctx, refundKey, public)
+	if err != nil {
+		return nil, wrapError(err, "failed to put state for earnest refund")
+	}
+
+	return public, nil
+}
+
+func _loadRefundAmount(ctx kalpsdk.TransactionContextInterface, refund *EarnestRefund) error {
+	refundKey, err := _refundKey(ctx, refund)
+	if err != nil {
+		return err
+	}
+
+	private := new(EarnestRefund)
+	found, err := _getPrivateCopy(ctx, refundKey, private)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return newError(CodeInternal, "earnest refund is missing from the private data collection").WithDetail("txId", refund.TxId)
+	}
+
+	refund.Buyer = private.Buyer
+	refund.Amount = private.Amount
+	refund.PaymentTxId = private.PaymentTxId
+	return nil
+}
+
+// _refundKey returns the key of the refund, which names the buyer by hash for confidential sales
+func _refundKey(ctx kalpsdk.TransactionContextInterface, refund *EarnestRefund) (string, error) {
+	buyer := refund.Buyer
+	if refund.Confidential {
+		buyer = refund.BuyerHash
+	}
+
+	return _compositeKey(ctx, earnestRefundPrefix, refund.TokenId, buyer, refund.TxId)
+}
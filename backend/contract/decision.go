@@ -0,0 +1,309 @@
+package contract
+
+import (
+	"encoding/json"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for the decisions taken on buy requests
+const saleDecisionPrefix = "saleDecision"
+
+// Define objectType name for the index of rejected buy requests by buyer. It is kept in the
+// saleTermsCollection, so rejections of confidential sales can be looked up without naming the buyer publicly.
+const buyerRejectionPrefix = "buyerRejection"
+
+// Decisions the inspector can take on a buy request
+const (
+	DecisionApprove     = "approve"
+	DecisionReject      = "reject"
+	DecisionConditional = "conditional"
+)
+
+// Reason codes of a rejection
+const (
+	RejectReasonUnspecified        = "unspecified" // Used by ApproveSale, which takes no reason
+	RejectReasonMissingDocuments   = "missing_documents"
+	RejectReasonTitleDefect        = "title_defect"
+	RejectReasonFinancing          = "financing"
+	RejectReasonPaymentUnconfirmed = "payment_unconfirmed"
+	RejectReasonComplianceHold     = "compliance_hold"
+	RejectReasonOther              = "other"
+)
+
+var rejectReasonCodes = []string{
+	RejectReasonUnspecified, RejectReasonMissingDocuments, RejectReasonTitleDefect, RejectReasonFinancing,
+	RejectReasonPaymentUnconfirmed, RejectReasonComplianceHold, RejectReasonOther,
+}
+
+// SaleCondition is an outstanding requirement of a conditional approval, such as a missing document or a financing letter
+type SaleCondition struct {
+	ConditionId  string `json:"conditionId"`
+	Description  string `json:"description"`
+	Satisfied    bool   `json:"satisfied"`
+	EvidenceHash string `json:"evidenceHash,omitempty"` // Evidence the inspector accepted, or the latest attached by a party
+	EvidenceBy   string `json:"evidenceBy,omitempty"`   // Party who attached the latest evidence
+	EvidenceAt   int64  `json:"evidenceAt,omitempty"`   // Unix seconds
+	SatisfiedBy  string `json:"satisfiedBy,omitempty"`
+	SatisfiedAt  int64  `json:"satisfiedAt,omitempty"` // Unix seconds
+}
+
+// SaleDecision is the inspector's decision on a buy request. DecideSale takes the decision,
+// reason code, note and conditions as JSON; the other fields are filled in by the contract.
+type SaleDecision struct {
+	TokenId    string           `json:"tokenId"`
+	Buyer      string           `json:"buyer"`
+	Decision   string           `json:"decision"`             // approve, reject or conditional
+	ReasonCode string           `json:"reasonCode,omitempty"` // Required for a rejection
+	Note       string           `json:"note,omitempty"`
+	Conditions []*SaleCondition `json:"conditions,omitempty"` // Required for a conditional approval
+	DecidedBy  string           `json:"decidedBy"`
+	DecidedAt  int64            `json:"decidedAt"` // Unix seconds
+	TxId       string           `json:"txId"`
+}
+
+// DecideSale allows the inspector to approve a buy request, reject it with a reason code and note,
+// or approve it on conditions. A conditionally approved sale completes once every condition is satisfied.
+func (c *TokenERC721Contract) DecideSale(ctx kalpsdk.TransactionContextInterface, tokenId string, decisionJSON string) (*SaleDecision, error) {
+	decision := new(SaleDecision)
+	err := json.Unmarshal([]byte(decisionJSON), decision)
+	if err != nil {
+		return nil, wrapErrorAs(CodeValidation, err, "failed to unmarshal sale decision")
+	}
+
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (*SaleDecision, error) {
+		return c.decideSale(ctx, tokenId, decision)
+	})
+}
+
+// SubmitConditionEvidence allows the buyer or the seller to attach evidence for a condition of a conditional
+// approval, such as the hash of a financing letter. Only the inspector can then mark the condition as met.
+func (c *TokenERC721Contract) SubmitConditionEvidence(ctx kalpsdk.TransactionContextInterface, tokenId string, conditionId string, evidenceHash string) (*SaleCondition, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get client identity")
+	}
+
+	if evidenceHash == "" {
+		return nil, newError(CodeValidation, "evidence hash must not be empty")
+	}
+
+	sale, condition, err := _readOpenCondition(ctx, tokenId, conditionId)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != sale.Buyer && clientID != sale.Seller {
+		return nil, newError(CodeUnauthorized, "only the buyer or the seller can submit evidence for a condition")
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	condition.EvidenceHash = evidenceHash
+	condition.EvidenceBy = clientID
+	condition.EvidenceAt = now
+
+	err = _putSale(ctx, sale)
+	if err != nil {
+		return nil, wrapError(err, "failed to update sale state")
+	}
+
+	err = _setJSONEvent(ctx, "ConditionEvidenceSubmitted", condition)
+	if err != nil {
+		return nil, err
+	}
+
+	return condition, nil
+}
+
+// SatisfyCondition allows the inspector to mark a condition of a conditional approval as met on the evidence
+// with evidenceHash. It returns true once the last condition is met and the NFT has been transferred to the buyer.
+func (c *TokenERC721Contract) SatisfyCondition(ctx kalpsdk.TransactionContextInterface, tokenId string, conditionId string, evidenceHash string) (bool, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+		return c.satisfyCondition(ctx, tokenId, conditionId, evidenceHash)
+	})
+}
+
+func (c *TokenERC721Contract) satisfyCondition(ctx kalpsdk.TransactionContextInterface, tokenId string, conditionId string, evidenceHash string) (bool, error) {
+	inspectorID, err := c._requireInspector(ctx, "satisfy a condition")
+	if err != nil {
+		return false, err
+	}
+
+	if evidenceHash == "" {
+		return false, newError(CodeValidation, "evidence hash must not be empty")
+	}
+
+	sale, condition, err := _readOpenCondition(ctx, tokenId, conditionId)
+	if err != nil {
+		return false, err
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	condition.Satisfied = true
+	condition.EvidenceHash = evidenceHash
+	condition.SatisfiedBy = inspectorID
+	condition.SatisfiedAt = now
+
+	// The sale completes with the last condition unless a higher review tier still has to sign off
+	completed, err := _advanceApproval(ctx, sale)
+	if err != nil {
+		return false, err
+	}
+	if !completed {
+		err = _setJSONEvent(ctx, "ConditionSatisfied", condition)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = _putSale(ctx, sale)
+	if err != nil {
+		return false, wrapError(err, "failed to update sale state")
+	}
+
+	return completed, nil
+}
+
+// _readOpenCondition returns the conditionally approved sale of the NFT and its unmet condition conditionId
+func _readOpenCondition(ctx kalpsdk.TransactionContextInterface, tokenId string, conditionId string) (*Sale, *SaleCondition, error) {
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !sale.IsPendingApproval || sale.IsApproved != DecisionConditional {
+		return nil, nil, newError(CodeInvalidState, "NFT has no conditionally approved sale").WithDetail("tokenId", tokenId)
+	}
+
+	err = _requireNoOpenDispute(ctx, tokenId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var condition *SaleCondition
+	for _, candidate := range sale.Conditions {
+		if candidate.ConditionId == conditionId {
+			condition = candidate
+		}
+	}
+	if condition == nil {
+		return nil, nil, newError(CodeNotFound, "condition not found").WithDetail("tokenId", tokenId).WithDetail("conditionId", conditionId)
+	}
+	if condition.Satisfied {
+		return nil, nil, newError(CodeConflict, "condition is already satisfied").WithDetail("conditionId", conditionId)
+	}
+
+	return sale, condition, nil
+}
+
+// GetSaleDecisions returns every decision taken on buy requests for an NFT, oldest first
+func (c *TokenERC721Contract) GetSaleDecisions(ctx kalpsdk.TransactionContextInterface, tokenId string) ([]*SaleDecision, error) {
+	return _listJSON[SaleDecision](ctx, saleDecisionPrefix, tokenId)
+}
+
+// _hasRejection reports whether a buy request of the buyer has ever been rejected
+func _hasRejection(ctx kalpsdk.TransactionContextInterface, buyerID string) (bool, error) {
+	stub, err := _stub(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	iterator, err := stub.GetPrivateDataByPartialCompositeKey(saleTermsCollection, buyerRejectionPrefix, []string{buyerID})
+	if err != nil {
+		return false, wrapError(err, "failed to get private data by partial composite key for %s", buyerRejectionPrefix)
+	}
+	defer iterator.Close()
+
+	return iterator.HasNext(), nil
+}
+
+func _validateDecision(decision *SaleDecision) error {
+	switch decision.Decision {
+	case DecisionApprove:
+		if len(decision.Conditions) > 0 {
+			return newError(CodeValidation, "an approval cannot have conditions, use a conditional approval")
+		}
+
+	case DecisionReject:
+		if !_isRejectReasonCode(decision.ReasonCode) {
+			return newError(CodeValidation, "unknown rejection reason code %s", decision.ReasonCode).WithDetail("reasonCode", decision.ReasonCode)
+		}
+		if len(decision.Conditions) > 0 {
+			return newError(CodeValidation, "a rejection cannot have conditions")
+		}
+
+	case DecisionConditional:
+		if len(decision.Conditions) == 0 {
+			return newError(CodeValidation, "a conditional approval needs at least one condition")
+		}
+		seen := make(map[string]bool)
+		for _, condition := range decision.Conditions {
+			if condition == nil || condition.ConditionId == "" || condition.Description == "" {
+				return newError(CodeValidation, "every condition needs an id and a description")
+			}
+			if seen[condition.ConditionId] {
+				return newError(CodeValidation, "duplicate condition id %s", condition.ConditionId).WithDetail("conditionId", condition.ConditionId)
+			}
+			seen[condition.ConditionId] = true
+
+			// Conditions start out unmet whatever the caller sent
+			*condition = SaleCondition{ConditionId: condition.ConditionId, Description: condition.Description}
+		}
+
+	default:
+		return newError(CodeValidation, "unknown decision %s", decision.Decision).WithDetail("decision", decision.Decision)
+	}
+
+	return nil
+}
+
+func _isRejectReasonCode(reasonCode string) bool {
+	for _, code := range rejectReasonCodes {
+		if reasonCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// _recordDecision stores the decision and, unless the sale completed, emits it as an event.
+// An approval already emits the Transfer event, and Fabric keeps only one event per transaction.
+// _recordDecision stores the decision on the buy request of buyerID, which the decision itself leaves
+// empty for a confidential sale, and indexes rejections by buyer
+func _recordDecision(ctx kalpsdk.TransactionContextInterface, decision *SaleDecision, buyerID string) error {
+	decision.TxId = ctx.GetTxID()
+	decisionKey, err := _compositeKey(ctx, saleDecisionPrefix, decision.TokenId, _sortableTime(decision.DecidedAt), decision.TxId)
+	if err != nil {
+		return err
+	}
+
+	err = _putJSON(ctx, decisionKey, decision)
+	if err != nil {
+		return wrapError(err, "failed to put state for sale decision")
+	}
+
+	if decision.Decision == DecisionReject {
+		rejectionKey, err := _compositeKey(ctx, buyerRejectionPrefix, buyerID, decision.TokenId, decision.TxId)
+		if err != nil {
+			return err
+		}
+		rejection := *decision
+		rejection.Buyer = buyerID
+		err = _putPrivateCopy(ctx, rejectionKey, &rejection)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch decision.Decision {
+	case DecisionReject:
+		return _setJSONEvent(ctx, "SaleRejected", decision)
+	case DecisionConditional:
+		return _setJSONEvent(ctx, "SaleConditionallyApproved", decision)
+	}
+	return nil
+}
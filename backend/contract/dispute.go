@@ -0,0 +1,499 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType names for disputes and the evidence submitted for them
+const disputePrefix = "dispute"
+const disputeEvidencePrefix = "disputeEvidence"
+
+// Role of the users who resolve disputes
+const arbitratorRole = "arbitrator"
+
+// Define key name for the dispute window of completed sales
+const disputeWindowKey = "disputeWindow"
+
+// Dispute window used until the admin configures one
+const defaultDisputeWindowDays = 30
+
+// States a disputed sale can be in when the dispute is opened
+const (
+	DisputedSaleInProgress = "in_progress"
+	DisputedSaleCompleted  = "completed"
+)
+
+// Outcomes of a dispute
+const (
+	DisputeOutcomeUphold = "uphold" // The sale stands
+	DisputeOutcomeRevert = "revert" // A completed sale is undone and the NFT goes back to the seller
+	DisputeOutcomeRefund = "refund" // A sale in progress is cancelled and the NFT is relisted
+)
+
+// Dispute is a contested sale of an NFT. While it is open the NFT is locked.
+type Dispute struct {
+	DisputeId  string `json:"disputeId"` // Id of the transaction that opened the dispute
+	TokenId    string `json:"tokenId"`
+	Seller     string `json:"seller"`
+	Buyer      string `json:"buyer"`     // Empty on the public record of a confidential sale
+	Earnest    int    `json:"earnest"`   // Zero on the public record of a confidential sale
+	SaleState  string `json:"saleState"` // in_progress or completed
+	OpenedBy   string `json:"openedBy"`  // Empty on the public record of a confidential sale if the buyer opened it
+	Reason     string `json:"reason"`
+	OpenedAt   int64  `json:"openedAt"` // Unix seconds
+	Resolved   bool   `json:"resolved"`
+	Outcome    string `json:"outcome,omitempty"`
+	ResolvedBy string `json:"resolvedBy,omitempty"`
+	ResolvedAt int64  `json:"resolvedAt,omitempty"`
+
+	// The buyer of a confidential sale is only kept in the private copy of the dispute
+	Confidential bool `json:"confidential,omitempty"`
+}
+
+// DisputeEvidence is a document submitted by a party to a dispute
+type DisputeEvidence struct {
+	DisputeId    string `json:"disputeId"`
+	TokenId      string `json:"tokenId"`
+	SubmittedBy  string `json:"submittedBy"` // Empty on the public record of a confidential sale if the buyer submitted it
+	EvidenceHash string `json:"evidenceHash"`
+	SubmittedAt  int64  `json:"submittedAt"` // Unix seconds
+	TxId         string `json:"txId"`
+
+	Confidential bool `json:"confidential,omitempty"`
+}
+
+// OpenDispute allows the buyer or seller of a sale in progress, or of a sale completed within the
+// dispute window, to contest it. The NFT can't be listed, bought, decided on or transferred until the dispute is resolved.
+func (c *TokenERC721Contract) OpenDispute(ctx kalpsdk.TransactionContextInterface, tokenId string, reason string, evidenceHash string) (*Dispute, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get client identity")
+	}
+
+	if reason == "" || evidenceHash == "" {
+		return nil, newError(CodeValidation, "dispute reason and evidence hash must not be empty")
+	}
+
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	saleState := ""
+	if sale.IsPendingApproval && sale.Buyer != "" {
+		saleState = DisputedSaleInProgress
+	} else if sale.IsApproved == "true" {
+		saleState = DisputedSaleCompleted
+	}
+	if saleState == "" {
+		return nil, newError(CodeInvalidState, "NFT has no sale in progress or completed sale to dispute").WithDetail("tokenId", tokenId)
+	}
+	if clientID != sale.Buyer && clientID != sale.Seller {
+		return nil, newError(CodeUnauthorized, "only the buyer or the seller can dispute a sale")
+	}
+
+	err = _requireNoOpenDispute(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sales completed before completion times were recorded are past any window
+	if saleState == DisputedSaleCompleted {
+		disputeWindow, err := _readDisputeWindow(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if sale.CompletedAt == 0 || now >= sale.CompletedAt+disputeWindow {
+			return nil, newError(CodeInvalidState, "the dispute window of the sale has closed").WithDetail("completedAt", sale.CompletedAt)
+		}
+	}
+
+	dispute := &Dispute{
+		DisputeId:    ctx.GetTxID(),
+		TokenId:      tokenId,
+		Seller:       sale.Seller,
+		Buyer:        sale.Buyer,
+		Earnest:      sale.Earnest,
+		SaleState:    saleState,
+		OpenedBy:     clientID,
+		Reason:       reason,
+		OpenedAt:     now,
+		Confidential: sale.Confidential,
+	}
+	public, err := _putDispute(ctx, dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = _recordEvidence(ctx, dispute, clientID, evidenceHash, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = _setJSONEvent(ctx, "DisputeOpened", public)
+	if err != nil {
+		return nil, err
+	}
+
+	return public, nil
+}
+
+// SetDisputeWindow allows the admin to set how many days after completion a sale can still be disputed
+func (c *TokenERC721Contract) SetDisputeWindow(ctx kalpsdk.TransactionContextInterface, days int) (bool, error) {
+	_, err := c._requireAdmin(ctx, "set the dispute window")
+	if err != nil {
+		return false, err
+	}
+
+	if days <= 0 {
+		return false, newError(CodeValidation, "dispute window must be positive")
+	}
+
+	err = _putJSON(ctx, disputeWindowKey, days)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for dispute window")
+	}
+
+	return true, nil
+}
+
+// SubmitEvidence allows the buyer or seller to add evidence to the open dispute of an NFT
+func (c *TokenERC721Contract) SubmitEvidence(ctx kalpsdk.TransactionContextInterface, tokenId string, evidenceHash string) (*DisputeEvidence, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get client identity")
+	}
+
+	if evidenceHash == "" {
+		return nil, newError(CodeValidation, "evidence hash must not be empty")
+	}
+
+	dispute, err := _readOpenDispute(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != dispute.Buyer && clientID != dispute.Seller {
+		return nil, newError(CodeUnauthorized, "only the buyer or the seller can submit evidence")
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := _recordEvidence(ctx, dispute, clientID, evidenceHash, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = _setJSONEvent(ctx, "EvidenceSubmitted", public)
+	if err != nil {
+		return nil, err
+	}
+
+	return public, nil
+}
+
+// ResolveDispute allows an arbitrator to close the open dispute of an NFT with one of the outcomes
+// uphold, revert or refund. Revert applies to completed sales and also marks their settlement reversed,
+// refund applies to sales in progress; both record the earnest money as owed back to the buyer, who reclaims it with ReclaimEarnest.
+func (c *TokenERC721Contract) ResolveDispute(ctx kalpsdk.TransactionContextInterface, tokenId string, outcome string) (*Dispute, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (*Dispute, error) {
+		return c.resolveDispute(ctx, tokenId, outcome)
+	})
+}
+
+func (c *TokenERC721Contract) resolveDispute(ctx kalpsdk.TransactionContextInterface, tokenId string, outcome string) (*Dispute, error) {
+	arbitratorID, err := _requireRole(ctx, arbitratorRole)
+	if err != nil {
+		return nil, err
+	}
+
+	dispute, err := _readOpenDispute(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	switch outcome {
+	case DisputeOutcomeUphold:
+	case DisputeOutcomeRevert:
+		if dispute.SaleState != DisputedSaleCompleted {
+			return nil, newError(CodeValidation, "only a completed sale can be reverted, refund a sale in progress").WithDetail("disputeId", dispute.DisputeId)
+		}
+	case DisputeOutcomeRefund:
+		if dispute.SaleState != DisputedSaleInProgress {
+			return nil, newError(CodeValidation, "only a sale in progress can be refunded, revert a completed sale").WithDetail("disputeId", dispute.DisputeId)
+		}
+	default:
+		return nil, newError(CodeValidation, "unknown dispute outcome %s", outcome).WithDetail("outcome", outcome)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Close the dispute first, so the token is no longer locked for the transfer back to the seller
+	dispute.Resolved = true
+	dispute.Outcome = outcome
+	dispute.ResolvedBy = arbitratorID
+	dispute.ResolvedAt = now
+	public, err := _putDispute(ctx, dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	if outcome != DisputeOutcomeUphold {
+		err = _applyDisputeOutcome(ctx, dispute)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Fabric keeps only one event per transaction, so a reverted transfer is reported here rather than by a Transfer event
+	err = _setJSONEvent(ctx, "DisputeResolved", public)
+	if err != nil {
+		return nil, err
+	}
+
+	return public, nil
+}
+
+// GetDisputes returns every dispute opened for an NFT, open or resolved
+func (c *TokenERC721Contract) GetDisputes(ctx kalpsdk.TransactionContextInterface, tokenId string) ([]*Dispute, error) {
+	return _listJSON[Dispute](ctx, disputePrefix, tokenId)
+}
+
+// GetDisputeEvidence returns the evidence submitted for a dispute, oldest first
+func (c *TokenERC721Contract) GetDisputeEvidence(ctx kalpsdk.TransactionContextInterface, tokenId string, disputeId string) ([]*DisputeEvidence, error) {
+	return _listJSON[DisputeEvidence](ctx, disputeEvidencePrefix, tokenId, disputeId)
+}
+
+// _applyDisputeOutcome cancels the disputed sale and records the earnest money owed back to the buyer.
+// A reverted sale also moves the NFT back to the seller and takes it off the market.
+func _applyDisputeOutcome(ctx kalpsdk.TransactionContextInterface, dispute *Dispute) error {
+	sale, err := _readSale(ctx, dispute.TokenId)
+	if err != nil {
+		return err
+	}
+
+	if dispute.Outcome == DisputeOutcomeRevert {
+		nft, err := _readNFT(ctx, dispute.TokenId)
+		if err != nil {
+			return err
+		}
+		if nft.Owner != dispute.Buyer {
+			return newError(CodeInvalidState, "NFT is no longer owned by the buyer").WithDetail("tokenId", dispute.TokenId).WithDetail("owner", nft.Owner)
+		}
+		err = _transferNFT(ctx, nft, dispute.Seller)
+		if err != nil {
+			return err
+		}
+
+		// Sales completed before settlements were linked to them have nothing to reverse
+		if sale.SettlementTxId != "" {
+			err = _reverseSettlement(ctx, dispute.TokenId, sale.SettlementTxId, dispute.DisputeId, dispute.ResolvedAt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	refund := &EarnestRefund{
+		TokenId:      dispute.TokenId,
+		Buyer:        dispute.Buyer,
+		Amount:       sale.Earnest,
+		PaymentTxId:  sale.PaymentTxId,
+		ExpiredAt:    dispute.ResolvedAt,
+		TxId:         ctx.GetTxID(),
+		Reason:       RefundReasonDispute,
+		Confidential: sale.Confidential,
+		termsSalt:    sale.termsSalt,
+	}
+	_, err = _putEarnestRefund(ctx, refund)
+	if err != nil {
+		return err
+	}
+
+	sale.clearBuyRequest()
+	if dispute.Outcome == DisputeOutcomeRevert {
+		sale.IsOnSale = false
+		sale.IsApproved = "reverted"
+	}
+	err = _putSale(ctx, sale)
+	if err != nil {
+		return wrapError(err, "failed to update sale state")
+	}
+
+	return nil
+}
+
+func _readDisputeWindow(ctx kalpsdk.TransactionContextInterface) (int64, error) {
+	days := defaultDisputeWindowDays
+	_, err := _getJSON(ctx, disputeWindowKey, &days)
+	if err != nil {
+		return 0, wrapError(err, "failed to get dispute window")
+	}
+
+	return int64(days) * 24 * 60 * 60, nil
+}
+
+// _requireNoOpenDispute fails while the NFT has an open dispute
+func _requireNoOpenDispute(ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+	dispute, err := _getOpenDispute(ctx, tokenId)
+	if err != nil {
+		return err
+	}
+	if dispute != nil {
+		return newError(CodeInvalidState, "NFT %s is locked by an open dispute", tokenId).
+			WithDetail("tokenId", tokenId).WithDetail("disputeId", dispute.DisputeId)
+	}
+
+	return nil
+}
+
+// _requireDisputeWindowClosed fails while the last sale of the NFT can still be disputed, so the
+// new owner can't list or pledge it away before the seller has had the chance to
+func _requireDisputeWindowClosed(ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+	sale, found, err := _getSale(ctx, tokenId)
+	if err != nil || !found || sale.IsApproved != "true" || sale.CompletedAt == 0 {
+		return err
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return err
+	}
+	disputeWindow, err := _readDisputeWindow(ctx)
+	if err != nil {
+		return err
+	}
+	if now < sale.CompletedAt+disputeWindow {
+		return newError(CodeInvalidState, "NFT %s is within the dispute window of its last sale", tokenId).
+			WithDetail("tokenId", tokenId).WithDetail("disputableUntil", sale.CompletedAt+disputeWindow)
+	}
+
+	return nil
+}
+
+func _getOpenDispute(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Dispute, error) {
+	disputes, err := _listJSON[Dispute](ctx, disputePrefix, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dispute := range disputes {
+		if dispute.Resolved {
+			continue
+		}
+		if dispute.Confidential {
+			return _loadDispute(ctx, dispute)
+		}
+		return dispute, nil
+	}
+
+	return nil, nil
+}
+
+func _loadDispute(ctx kalpsdk.TransactionContextInterface, dispute *Dispute) (*Dispute, error) {
+	disputeKey, err := _compositeKey(ctx, disputePrefix, dispute.TokenId, dispute.DisputeId)
+	if err != nil {
+		return nil, err
+	}
+
+	private := new(Dispute)
+	found, err := _getPrivateCopy(ctx, disputeKey, private)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, newError(CodeInternal, "dispute is missing from the private data collection").WithDetail("disputeId", dispute.DisputeId)
+	}
+
+	return private, nil
+}
+
+func _readOpenDispute(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Dispute, error) {
+	dispute, err := _getOpenDispute(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if dispute == nil {
+		return nil, newError(CodeNotFound, "NFT has no open dispute").WithDetail("tokenId", tokenId)
+	}
+
+	return dispute, nil
+}
+
+// _putDispute stores the dispute and returns its public record. The buyer and earnest money of a
+// confidential sale go to the private data collection only.
+func _putDispute(ctx kalpsdk.TransactionContextInterface, dispute *Dispute) (*Dispute, error) {
+	disputeKey, err := _compositeKey(ctx, disputePrefix, dispute.TokenId, dispute.DisputeId)
+	if err != nil {
+		return nil, err
+	}
+
+	public := dispute
+	if dispute.Confidential {
+		err = _putPrivateCopy(ctx, disputeKey, dispute)
+		if err != nil {
+			return nil, err
+		}
+		redacted := *dispute
+		redacted.Buyer = ""
+		redacted.Earnest = 0
+		if redacted.OpenedBy == dispute.Buyer {
+			redacted.OpenedBy = ""
+		}
+		public = &redacted
+	}
+
+	err = _putJSON(ctx, disputeKey, public)
+	if err != nil {
+		return nil, wrapError(err, "failed to put state for dispute")
+	}
+
+	return public, nil
+}
+
+// _recordEvidence stores the evidence and returns its public record, which doesn't name the buyer of a confidential sale
+func _recordEvidence(ctx kalpsdk.TransactionContextInterface, dispute *Dispute, submittedBy string, evidenceHash string, now int64) (*DisputeEvidence, error) {
+	evidence := &DisputeEvidence{
+		DisputeId:    dispute.DisputeId,
+		TokenId:      dispute.TokenId,
+		SubmittedBy:  submittedBy,
+		EvidenceHash: evidenceHash,
+		SubmittedAt:  now,
+		TxId:         ctx.GetTxID(),
+		Confidential: dispute.Confidential,
+	}
+
+	// Evidence keys contain the zero-padded timestamp, so they are listed oldest first
+	evidenceKey, err := _compositeKey(ctx, disputeEvidencePrefix, evidence.TokenId, evidence.DisputeId, _sortableTime(now), evidence.TxId)
+	if err != nil {
+		return nil, err
+	}
+
+	public := evidence
+	if evidence.Confidential && submittedBy == dispute.Buyer {
+		err = _putPrivateCopy(ctx, evidenceKey, evidence)
+		if err != nil {
+			return nil, err
+		}
+		redacted := *evidence
+		redacted.SubmittedBy = ""
+		public = &redacted
+	}
+
+	err = _putJSON(ctx, evidenceKey, public)
+	if err != nil {
+		return nil, wrapError(err, "failed to put state for dispute evidence")
+	}
+
+	return public, nil
+}
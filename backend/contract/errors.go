@@ -0,0 +1,92 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is a stable identifier clients can switch on instead of matching error messages
+type ErrorCode string
+
+// Define error codes returned by the contract
+const (
+	CodeNotFound     ErrorCode = "NOT_FOUND"
+	CodeUnauthorized ErrorCode = "UNAUTHORIZED"
+	CodeInvalidState ErrorCode = "INVALID_STATE"
+	CodeValidation   ErrorCode = "VALIDATION"
+	CodeConflict     ErrorCode = "CONFLICT"
+	CodeInternal     ErrorCode = "INTERNAL" // Ledger or peer failures outside the caller's control
+)
+
+// ContractError is returned by every contract function. Its Error() is the JSON object
+// {"code", "message", "details"}, which the chaincode returns as the error payload.
+type ContractError struct {
+	Code    ErrorCode              `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+	Err     error                  `json:"-"` // Wrapped cause, reachable through errors.Is and errors.As
+}
+
+func (e *ContractError) Error() string {
+	errorBytes, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf(`{"code":%q,"message":%q}`, e.Code, e.Message)
+	}
+	return string(errorBytes)
+}
+
+func (e *ContractError) Unwrap() error {
+	return e.Err
+}
+
+// WithDetail adds a detail to the error and returns it
+func (e *ContractError) WithDetail(key string, value interface{}) *ContractError {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+// newError creates a ContractError with the given code
+func newError(code ErrorCode, format string, args ...interface{}) *ContractError {
+	return &ContractError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapError wraps err with a message prefix. The code and details of a wrapped
+// ContractError are kept, any other cause is reported as INTERNAL.
+func wrapError(err error, format string, args ...interface{}) *ContractError {
+	var cause *ContractError
+	if errors.As(err, &cause) {
+		wrapped := wrapErrorAs(cause.Code, err, format, args...)
+		for key, value := range cause.Details {
+			wrapped.WithDetail(key, value)
+		}
+		return wrapped
+	}
+	return wrapErrorAs(CodeInternal, err, format, args...)
+}
+
+// wrapErrorAs wraps err with a message prefix and the given code
+func wrapErrorAs(code ErrorCode, err error, format string, args ...interface{}) *ContractError {
+	return &ContractError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...) + ": " + errorMessage(err),
+		Err:     err,
+	}
+}
+
+// errorMessage returns the plain message of err, without the JSON envelope of a ContractError
+func errorMessage(err error) string {
+	if contractErr, ok := err.(*ContractError); ok {
+		return contractErr.Message
+	}
+	return err.Error()
+}
+
+// IsErrorCode reports whether any error in err's chain is a ContractError with the code
+func IsErrorCode(err error, code ErrorCode) bool {
+	var contractErr *ContractError
+	return errors.As(err, &contractErr) && contractErr.Code == code
+}
@@ -0,0 +1,265 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType names for active freezes and the freeze audit trail
+const freezePrefix = "freeze"
+const freezeHistoryPrefix = "freezeHistory"
+
+// Kinds of freeze targets
+const (
+	FreezeTargetToken   = "token"
+	FreezeTargetAccount = "account"
+)
+
+// FreezeRecord is stored while a token or account is frozen
+type FreezeRecord struct {
+	TargetType string `json:"targetType"`
+	TargetId   string `json:"targetId"`
+	Reason     string `json:"reason"`
+	FrozenBy   string `json:"frozenBy"`
+	FrozenAt   int64  `json:"frozenAt"` // Unix seconds
+}
+
+// FreezeAction is an entry of the freeze audit trail of a token or account
+type FreezeAction struct {
+	TargetType string `json:"targetType"`
+	TargetId   string `json:"targetId"`
+	Action     string `json:"action"` // freeze or unfreeze
+	Reason     string `json:"reason"`
+	By         string `json:"by"`
+	At         int64  `json:"at"` // Unix seconds
+	TxId       string `json:"txId"`
+}
+
+// FreezeStatus is returned by GetTokenFreeze and GetAccountFreeze
+type FreezeStatus struct {
+	TargetType string        `json:"targetType"`
+	TargetId   string        `json:"targetId"`
+	IsFrozen   bool          `json:"isFrozen"`
+	Freeze     *FreezeRecord `json:"freeze,omitempty"`
+}
+
+// FreezeToken allows a compliance officer to freeze an NFT, so it can't be listed, bought, approved or transferred
+func (c *TokenERC721Contract) FreezeToken(ctx kalpsdk.TransactionContextInterface, tokenId string, reason string) (bool, error) {
+	exists, err := _nftExists(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, errNFTNotFound(tokenId)
+	}
+
+	return _freeze(ctx, FreezeTargetToken, tokenId, reason)
+}
+
+// UnfreezeToken allows a compliance officer to lift the freeze of an NFT
+func (c *TokenERC721Contract) UnfreezeToken(ctx kalpsdk.TransactionContextInterface, tokenId string, reason string) (bool, error) {
+	return _unfreeze(ctx, FreezeTargetToken, tokenId, reason)
+}
+
+// FreezeAccount allows a compliance officer to freeze a user, who then can't take part in any trade
+func (c *TokenERC721Contract) FreezeAccount(ctx kalpsdk.TransactionContextInterface, userId string, reason string) (bool, error) {
+	return _freeze(ctx, FreezeTargetAccount, userId, reason)
+}
+
+// UnfreezeAccount allows a compliance officer to lift the freeze of a user
+func (c *TokenERC721Contract) UnfreezeAccount(ctx kalpsdk.TransactionContextInterface, userId string, reason string) (bool, error) {
+	return _unfreeze(ctx, FreezeTargetAccount, userId, reason)
+}
+
+// GetTokenFreeze returns whether an NFT is frozen and why
+func (c *TokenERC721Contract) GetTokenFreeze(ctx kalpsdk.TransactionContextInterface, tokenId string) (*FreezeStatus, error) {
+	return _getFreezeStatus(ctx, FreezeTargetToken, tokenId)
+}
+
+// GetAccountFreeze returns whether a user is frozen and why
+func (c *TokenERC721Contract) GetAccountFreeze(ctx kalpsdk.TransactionContextInterface, userId string) (*FreezeStatus, error) {
+	return _getFreezeStatus(ctx, FreezeTargetAccount, userId)
+}
+
+// GetFreezeHistory returns every freeze and unfreeze of a token or account, oldest first
+func (c *TokenERC721Contract) GetFreezeHistory(ctx kalpsdk.TransactionContextInterface, targetType string, targetId string) ([]*FreezeAction, error) {
+	err := _validateFreezeTarget(targetType, targetId)
+	if err != nil {
+		return nil, err
+	}
+
+	// History keys contain the zero-padded timestamp, so they are listed oldest first
+	return _listJSON[FreezeAction](ctx, freezeHistoryPrefix, targetType, targetId)
+}
+
+func _freeze(ctx kalpsdk.TransactionContextInterface, targetType string, targetId string, reason string) (bool, error) {
+	officerID, err := _requireRole(ctx, complianceRole)
+	if err != nil {
+		return false, err
+	}
+
+	err = _validateFreezeTarget(targetType, targetId)
+	if err != nil {
+		return false, err
+	}
+	if reason == "" {
+		return false, newError(CodeValidation, "freeze reason must not be empty")
+	}
+
+	_, frozen, err := _getFreeze(ctx, targetType, targetId)
+	if err != nil {
+		return false, err
+	}
+	if frozen {
+		return false, newError(CodeConflict, "%s %s is already frozen", targetType, targetId).WithDetail(targetType, targetId)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	freezeKey, err := _compositeKey(ctx, freezePrefix, targetType, targetId)
+	if err != nil {
+		return false, err
+	}
+	record := FreezeRecord{TargetType: targetType, TargetId: targetId, Reason: reason, FrozenBy: officerID, FrozenAt: now}
+	err = _putJSON(ctx, freezeKey, record)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for freeze")
+	}
+
+	action := &FreezeAction{TargetType: targetType, TargetId: targetId, Action: "freeze", Reason: reason, By: officerID, At: now}
+	err = _recordFreezeAction(ctx, action)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func _unfreeze(ctx kalpsdk.TransactionContextInterface, targetType string, targetId string, reason string) (bool, error) {
+	officerID, err := _requireRole(ctx, complianceRole)
+	if err != nil {
+		return false, err
+	}
+
+	err = _validateFreezeTarget(targetType, targetId)
+	if err != nil {
+		return false, err
+	}
+
+	_, frozen, err := _getFreeze(ctx, targetType, targetId)
+	if err != nil {
+		return false, err
+	}
+	if !frozen {
+		return false, newError(CodeInvalidState, "%s %s is not frozen", targetType, targetId).WithDetail(targetType, targetId)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	freezeKey, err := _compositeKey(ctx, freezePrefix, targetType, targetId)
+	if err != nil {
+		return false, err
+	}
+	err = ctx.DelStateWithoutKYC(freezeKey)
+	if err != nil {
+		return false, wrapError(err, "failed to delete freeze")
+	}
+
+	action := &FreezeAction{TargetType: targetType, TargetId: targetId, Action: "unfreeze", Reason: reason, By: officerID, At: now}
+	err = _recordFreezeAction(ctx, action)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// _recordFreezeAction appends the action to the audit trail and emits it as a Frozen or Unfrozen event
+func _recordFreezeAction(ctx kalpsdk.TransactionContextInterface, action *FreezeAction) error {
+	action.TxId = ctx.GetTxID()
+	historyKey, err := _compositeKey(ctx, freezeHistoryPrefix, action.TargetType, action.TargetId, _sortableTime(action.At), action.TxId)
+	if err != nil {
+		return err
+	}
+
+	err = _putJSON(ctx, historyKey, action)
+	if err != nil {
+		return wrapError(err, "failed to put state for freeze history")
+	}
+
+	eventName := "Frozen"
+	if action.Action == "unfreeze" {
+		eventName = "Unfrozen"
+	}
+	return _setJSONEvent(ctx, eventName, action)
+}
+
+func _getFreeze(ctx kalpsdk.TransactionContextInterface, targetType string, targetId string) (*FreezeRecord, bool, error) {
+	freezeKey, err := _compositeKey(ctx, freezePrefix, targetType, targetId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	record := new(FreezeRecord)
+	found, err := _getJSON(ctx, freezeKey, record)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	return record, true, nil
+}
+
+func _getFreezeStatus(ctx kalpsdk.TransactionContextInterface, targetType string, targetId string) (*FreezeStatus, error) {
+	err := _validateFreezeTarget(targetType, targetId)
+	if err != nil {
+		return nil, err
+	}
+
+	record, frozen, err := _getFreeze(ctx, targetType, targetId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FreezeStatus{TargetType: targetType, TargetId: targetId, IsFrozen: frozen, Freeze: record}, nil
+}
+
+// _requireNotFrozen fails if the NFT or any of the accounts taking part in a trade is frozen
+func _requireNotFrozen(ctx kalpsdk.TransactionContextInterface, tokenId string, accounts ...string) error {
+	record, frozen, err := _getFreeze(ctx, FreezeTargetToken, tokenId)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return newError(CodeInvalidState, "NFT %s is frozen: %s", tokenId, record.Reason).WithDetail("tokenId", tokenId)
+	}
+
+	for _, account := range accounts {
+		if account == "" {
+			continue
+		}
+		record, frozen, err := _getFreeze(ctx, FreezeTargetAccount, account)
+		if err != nil {
+			return err
+		}
+		if frozen {
+			return newError(CodeInvalidState, "account %s is frozen: %s", account, record.Reason).WithDetail("userId", account)
+		}
+	}
+
+	return nil
+}
+
+func _validateFreezeTarget(targetType string, targetId string) error {
+	if targetType != FreezeTargetToken && targetType != FreezeTargetAccount {
+		return newError(CodeValidation, "unknown freeze target type %s", targetType)
+	}
+	if targetId == "" {
+		return newError(CodeValidation, "%s must not be empty", targetType)
+	}
+	return nil
+}
@@ -0,0 +1,882 @@
+// Package contract implements the EstateX real estate NFT marketplace chaincode.
+package contract
+
+import (
+	"encoding/json"
+	"strconv"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType names for prefix
+const balancePrefix = "balance"
+const nftPrefix = "nft"
+const salePrefix = "sale"
+const tokenCounterKey = "tokenCounter"
+
+// Define key names for options
+const nameKey = "name"
+const symbolKey = "symbol"
+const inspectorAddress = "3a94baaef8c1ac6fd16bbf8dc6c6393655f65ab0" // Inspector of deployments initialized before the config was persisted
+
+
+type TokenURI struct {
+	Name        string      `json:"name"`
+	Address     string      `json:"address"`
+	Description string      `json:"description"`
+	Image       string      `json:"image"`
+	Id          string      `json:"id"`
+	Attributes  []Attribute `json:"attributes"`
+}
+
+type Attribute struct {
+	TraitType string      `json:"trait_type"`
+	Value     interface{} `json:"value"`
+}
+
+type Nft struct {
+	TokenId  string `json:"tokenId"`
+	Owner    string `json:"owner"`
+	TokenURI TokenURI `json:"tokenURI"`
+	Approved string `json:"approved"`
+	Royalty  Royalty `json:"royalty"`
+	Version  int `json:"version"` // Schema version the record was written with
+	Pledge   *Pledge `json:"pledge,omitempty"` // Collateral pledge, filled in by GetNFTMetadata and never stored
+}
+
+type Transfer struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	TokenId string `json:"tokenId"`
+	EndedLease *Lease `json:"endedLease,omitempty"` // Lease ended by the sale, since only one event survives per transaction
+}
+
+// Sale represents an NFT on sale
+type Sale struct {
+	TokenId    string  `json:"tokenId"`
+	Seller     string  `json:"seller"`
+	Price      int `json:"price"` // Asking price
+	IsOnSale   bool    `json:"isOnSale"`
+	IsPendingApproval bool   `json:"isPendingApproval"`  // New field for pending approval status
+	Earnest    int `json:"earnest"`
+	Buyer      string  `json:"buyer"`
+	IsApproved string    `json:"isApproved"`
+	PaymentTxId string   `json:"paymentTxId,omitempty"` // Gateway payment id of the PAYMENT-INFO record of a fiat-settled purchase
+	LeaseSurvivesSale bool `json:"leaseSurvivesSale"` // Whether the tenant keeps the lease when the NFT is sold
+	PriceFlag   string `json:"priceFlag,omitempty"`   // Set if the asking price is outside the appraisal band
+	EarnestFlag string `json:"earnestFlag,omitempty"` // Set if the earnest money is outside the appraisal band
+	RequestedAt    int64 `json:"requestedAt,omitempty"`    // Unix seconds of the buy request
+	ReviewDeadline int64 `json:"reviewDeadline,omitempty"` // Unix seconds after which the request expires
+	Conditions []*SaleCondition `json:"conditions,omitempty"` // Outstanding conditions of a conditional approval
+	SellerAccepted bool `json:"sellerAccepted,omitempty"` // Set once the co-owners of a co-owned NFT accept the buy request
+	Allowlist *SaleAllowlist `json:"allowlist,omitempty"` // Buyers a private listing is restricted to, by Merkle root on the public record
+	Confidential bool `json:"confidential,omitempty"` // Price, earnest and buyer are kept in the saleTermsCollection
+	TermsHash string `json:"termsHash,omitempty"` // SHA-256 of the sale terms, which are private for a confidential sale
+	termsSalt string // Salt of the private terms, never stored on the public record
+	SignOffs []*SignOff `json:"signOffs,omitempty"` // Approvals by the review tiers
+	AwaitingTier string `json:"awaitingTier,omitempty"` // Review tier the sale is waiting for after the inspector approved it
+	CompletedAt int64 `json:"completedAt,omitempty"` // Unix seconds the NFT was transferred to the buyer, which opens the dispute window
+	SettlementTxId string `json:"settlementTxId,omitempty"` // Key of the settlement record of the completed sale
+	Version    int `json:"version"` // Schema version the record was written with
+}
+
+// SaleWithMetadata combines the Sale information with the NFT metadata
+type SaleWithMetadata struct {
+	Sale       Sale  `json:"sale"`
+	NftMetadata Nft  `json:"nftMetadata"`
+	Payment    *kalpsdk.PaymentTracker `json:"payment,omitempty"` // Payment confirmation for the inspector
+}
+
+type TokenERC721Contract struct {
+	kalpsdk.Contract
+	deployer string
+}
+
+// NewTokenERC721Contract creates the marketplace contract administered by deployer
+func NewTokenERC721Contract(contract kalpsdk.Contract, deployer string) *TokenERC721Contract {
+	return &TokenERC721Contract{contract, deployer}
+}
+
+// Initialize sets the token name and symbol and persists the contract config. It can only be called once,
+// by the deployer the contract was built with, who becomes the admin.
+func (c *TokenERC721Contract) Initialize(ctx kalpsdk.TransactionContextInterface, name string, symbol string, inspector string) (bool, error) {
+	// Only the deployer can call this function
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get client identity")
+	}
+	if clientID != c.deployer {
+		return false, newError(CodeUnauthorized, "only the deployer can initialize the contract")
+	}
+
+	initialized, err := checkInitialized(ctx)
+	if err != nil {
+		return false, wrapError(err, "failed to check if contract is already initialized")
+	}
+	if initialized {
+		return false, newError(CodeConflict, "contract is already initialized")
+	}
+
+	if name == "" || symbol == "" || inspector == "" {
+		return false, newError(CodeValidation, "name, symbol and inspector must not be empty")
+	}
+
+	// Store the token name and symbol in state
+	err = ctx.PutStateWithoutKYC(nameKey, []byte(name))
+	if err != nil {
+		return false, wrapError(err, "failed to put state for name")
+	}
+	err = ctx.PutStateWithoutKYC(symbolKey, []byte(symbol))
+	if err != nil {
+		return false, wrapError(err, "failed to put state for symbol")
+	}
+
+	config := &ContractConfig{Name: name, Symbol: symbol, Admin: clientID, Inspector: inspector}
+	err = _putJSON(ctx, contractConfigKey, config)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for contract config")
+	}
+
+	return true, nil
+}
+
+// MintWithTokenURIWithDetails allows minting a new NFT with detailed tokenURI metadata
+func (c *TokenERC721Contract) MintWithTokenURIWithDetails(
+	ctx kalpsdk.TransactionContextInterface, 
+	tokenId string, 
+	name string, 
+	address string, 
+	description string, 
+	image string,
+	residenceType string, 
+	bedrooms int, 
+	bathrooms int, 
+	squareFeet int, 
+	yearBuilt int,
+	royaltyReceiver string,
+	royaltyBasisPoints int) (*Nft, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (*Nft, error) {
+		return c.mintWithTokenURIWithDetails(ctx, tokenId, name, address, description, image,
+			residenceType, bedrooms, bathrooms, squareFeet, yearBuilt, royaltyReceiver, royaltyBasisPoints)
+	})
+}
+
+func (c *TokenERC721Contract) mintWithTokenURIWithDetails(
+	ctx kalpsdk.TransactionContextInterface, 
+	tokenId string, 
+	name string, 
+	address string, 
+	description string, 
+	image string,
+	residenceType string, 
+	bedrooms int, 
+	bathrooms int, 
+	squareFeet int, 
+	yearBuilt int,
+	royaltyReceiver string,
+	royaltyBasisPoints int) (*Nft, error) {
+
+	// Check if contract has been initialized
+	initialized, err := checkInitialized(ctx)
+	if err != nil {
+		return nil, wrapError(err, "failed to check if contract is already initialized")
+	}
+	if !initialized {
+		return nil, newError(CodeInvalidState, "contract options need to be set before calling any function, call Initialize() to initialize contract")
+	}
+
+	// Check if the caller is the admin
+	clientID, err := c._requireAdmin(ctx, "mint new tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	err = _requireNotPaused(ctx, ScopeMint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the royalty owed to royaltyReceiver on every settled sale
+	if royaltyBasisPoints < 0 || royaltyBasisPoints > maxBasisPoints {
+		return nil, newError(CodeValidation, "royalty must be between 0 and %d basis points", maxBasisPoints)
+	}
+	if royaltyBasisPoints > 0 && royaltyReceiver == "" {
+		return nil, newError(CodeValidation, "royalty receiver must not be empty")
+	}
+
+	// Check if the token to be minted already exists
+	exists, err := _nftExists(ctx, tokenId)
+	if err != nil {
+		return nil, wrapError(err, "failed to check if the token %s is already minted", tokenId)
+	}
+	if exists {
+		return nil, newError(CodeConflict, "the token %s is already minted", tokenId).WithDetail("tokenId", tokenId)
+	}
+
+	// Get the current tokenCounter from the ledger (to ensure tokenId starts from 1 and increments)
+	tokenCounter := 0
+	_, err = _getJSON(ctx, tokenCounterKey, &tokenCounter)
+	if err != nil {
+		return nil, wrapError(err, "failed to get tokenCounter")
+	}
+	// Increment the tokenCounter
+	tokenCounter++
+
+	// Convert tokenCounter to string for use as tokenId
+	tokenId = strconv.Itoa(tokenCounter)
+
+	// Create the detailed tokenURI metadata
+	tokenURI := TokenURI{
+		Name:        name,
+		Address:     address,
+		Description: description,
+		Image:       image,
+		Id:          tokenId,
+		Attributes: []Attribute{
+			{TraitType: "Type of Residence", Value: residenceType},
+			{TraitType: "Bedrooms", Value: bedrooms},
+			{TraitType: "Bathrooms", Value: bathrooms},
+			{TraitType: "Square Feet", Value: squareFeet},
+			{TraitType: "Year Built", Value: yearBuilt},
+		},
+	}
+
+	// // Convert tokenURI to JSON format
+	// tokenURIBytes, err := json.Marshal(tokenURI)
+	// if err != nil {
+	// 	return nil, fmt.Errorf("failed to marshal tokenURI: %v", err)
+	// }
+
+	// Add the non-fungible token to the blockchain state
+	nft := &Nft{
+		TokenId:  tokenId,
+		Owner:    clientID,
+		TokenURI: tokenURI, // Store as a JSON string
+		Royalty:  Royalty{Receiver: royaltyReceiver, BasisPoints: royaltyBasisPoints},
+	}
+
+	err = _putNFT(ctx, nft)
+	if err != nil {
+		return nil, wrapError(err, "failed to put state")
+	}
+
+	// Emit transfer event for minting
+	transferEvent := Transfer{From: "0x0", To: clientID, TokenId: tokenId}
+	eventBytes, err := json.Marshal(transferEvent)
+	if err != nil {
+		return nil, wrapError(err, "failed to marshal transfer event")
+	}
+
+	err = ctx.SetEvent("Transfer", eventBytes)
+	if err != nil {
+		return nil, wrapError(err, "failed to set transfer event")
+	}
+
+	// Save the updated tokenCounter in the state
+	err = _putJSON(ctx, tokenCounterKey, tokenCounter)
+	if err != nil {
+		return nil, wrapError(err, "failed to put updated tokenCounter in state")
+	}
+
+	// Minting starts trading, which closes the state import window
+	err = _openMarketplace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return nft, nil
+}
+
+// ListNFTForSale allows the owner to list their NFT for sale. A lease on the NFT carries through the sale.
+func (c *TokenERC721Contract) ListNFTForSale(ctx kalpsdk.TransactionContextInterface, tokenId string, price int) (bool, error) {
+	return c.ListNFTForSaleWithLease(ctx, tokenId, price, true)
+}
+
+// ListNFTForSaleWithLease allows the owner to list their NFT for sale, choosing whether the current lease survives the sale
+func (c *TokenERC721Contract) ListNFTForSaleWithLease(ctx kalpsdk.TransactionContextInterface, tokenId string, price int, leaseSurvivesSale bool) (bool, error) {
+	ownerID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get owner identity")
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return false, wrapError(err, "failed to read NFT")
+	}
+
+	if nft.Owner != ownerID {
+		return false, newError(CodeUnauthorized, "only the owner can list the NFT for sale")
+	}
+
+	err = _requireSoleOwner(ctx, tokenId, ActionList)
+	if err != nil {
+		return false, err
+	}
+
+	err = _listNFT(ctx, nft, &Sale{Price: price, LeaseSurvivesSale: leaseSurvivesSale})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// _listNFT puts the NFT on sale on behalf of its owner. The listing sets the price and the
+// listing options; a nil allowlist makes the listing public.
+func _listNFT(ctx kalpsdk.TransactionContextInterface, nft *Nft, listing *Sale) error {
+	tokenId, ownerID, allowlist := nft.TokenId, nft.Owner, listing.Allowlist
+	err := _requireNotPaused(ctx, ScopeList)
+	if err != nil {
+		return err
+	}
+
+	if allowlist != nil {
+		err = _validateAllowlist(allowlist)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = _requireNotPledged(ctx, tokenId)
+	if err != nil {
+		return err
+	}
+
+	err = _requireNotFrozen(ctx, tokenId, ownerID)
+	if err != nil {
+		return err
+	}
+
+	err = _requireNoOpenDispute(ctx, tokenId)
+	if err != nil {
+		return err
+	}
+
+	// Relisting would erase the completed sale the seller can still dispute
+	err = _requireDisputeWindowClosed(ctx, tokenId)
+	if err != nil {
+		return err
+	}
+
+	// Relisting would drop the buyer's earnest money without recording a refund
+	existing, listed, err := _getSale(ctx, tokenId)
+	if err != nil {
+		return err
+	}
+	if listed && existing.IsPendingApproval {
+		return newError(CodeInvalidState, "NFT has a buy request pending approval").WithDetail("tokenId", tokenId)
+	}
+
+	priceFlag, err := _checkPriceBand(ctx, tokenId, "asking price", listing.Price)
+	if err != nil {
+		return err
+	}
+
+	// Create the sale object
+	sale := listing
+	sale.TokenId = tokenId
+	sale.Seller = ownerID
+	sale.IsOnSale = true
+	sale.PriceFlag = priceFlag
+
+	err = _putSale(ctx, sale)
+	if err != nil {
+		return wrapError(err, "failed to put state for sale")
+	}
+
+	return _openMarketplace(ctx)
+}
+
+// GetAllNFTs retrieves all NFTs from the ledger
+func (c *TokenERC721Contract) GetAllNFTs(ctx kalpsdk.TransactionContextInterface) ([]*Nft, error) {
+	allNFTs, err := _listJSON[Nft](ctx, nftPrefix)
+	if err != nil {
+		return nil, wrapError(err, "failed to get NFTs")
+	}
+
+	// Return the list of all NFTs
+	return allNFTs, nil
+}
+
+// GetNFTsOnSale returns all NFTs that are currently listed for sale, along with their metadata
+func (c *TokenERC721Contract) GetNFTsOnSale(ctx kalpsdk.TransactionContextInterface) ([]*SaleWithMetadata, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return nil, wrapError(err, "failed to get client identity")
+	}
+
+	sales, err := _listSales(ctx)
+	if err != nil {
+		return nil, wrapError(err, "failed to get sale listings")
+	}
+
+	// Create a slice to hold the NFTs that are on sale with their metadata
+	var nftsOnSale []*SaleWithMetadata
+
+	for _, sale := range sales {
+		// Only process if the NFT is on sale
+		if !sale.IsOnSale {
+			continue
+		}
+
+		// Private listings are only shown to the seller and the buyers on the allowlist
+		allowed, err := _isAllowlisted(ctx, sale, clientID)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			continue
+		}
+		if clientID != sale.Seller {
+			sale.Allowlist = sale.Allowlist.public()
+		}
+
+		// Combine Sale and NFT Metadata
+		saleWithMetadata, err := _withMetadata(ctx, sale)
+		if err != nil {
+			return nil, err
+		}
+
+		// Add to the list of NFTs on sale
+		nftsOnSale = append(nftsOnSale, saleWithMetadata)
+	}
+
+	// Return the list of NFTs on sale with their metadata
+	return nftsOnSale, nil
+}
+
+
+// BuyNFT allows a buyer to send earnest money and request a sale approval
+func (c *TokenERC721Contract) BuyNFT(ctx kalpsdk.TransactionContextInterface, tokenId string, earnest int) (bool, error) {
+	buyerID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get buyer identity")
+	}
+
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+		return _placeBuyRequest(ctx, tokenId, buyerID, earnest, "", false)
+	})
+}
+
+// _placeBuyRequest records the buyer and earnest money on the sale and marks it pending approval, unless
+// the auto-approval policy approves it. It must run in a state cache.
+// paymentTxId links the PAYMENT-INFO record of a fiat-settled purchase, if any. confidential is set
+// when the earnest money came in as transient data, which confidential listings require.
+func _placeBuyRequest(ctx kalpsdk.TransactionContextInterface, tokenId string, buyerID string, earnest int, paymentTxId string, confidential bool) (bool, error) {
+	err := _requireNotPaused(ctx, ScopeBuy)
+	if err != nil {
+		return false, err
+	}
+
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	if !sale.IsOnSale {
+		return false, newError(CodeInvalidState, "NFT is not on sale").WithDetail("tokenId", tokenId)
+	}
+
+	// The pending request keeps its earnest money, sign-offs and conditions until it is decided or expires
+	if sale.IsPendingApproval {
+		return false, newError(CodeInvalidState, "NFT has a buy request pending approval").WithDetail("tokenId", tokenId)
+	}
+
+	if sale.Confidential && !confidential {
+		return false, newError(CodeValidation, "NFT is listed for confidential sale, buy it with BuyNFTConfidential").WithDetail("tokenId", tokenId)
+	}
+
+	err = _requireNotFrozen(ctx, tokenId, buyerID, sale.Seller)
+	if err != nil {
+		return false, err
+	}
+
+	err = _requireNoOpenDispute(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	err = _requireAllowlisted(ctx, sale, buyerID)
+	if err != nil {
+		return false, err
+	}
+
+	if earnest < sale.Price {
+		return false, newError(CodeValidation, "earnest money must be equal to or greater than the asking price").WithDetail("price", sale.Price)
+	}
+
+	earnestFlag, err := _checkPriceBand(ctx, tokenId, "earnest money", earnest)
+	if err != nil {
+		return false, err
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	reviewPeriod, err := _readReviewPeriod(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// Update sale with buyer information and earnest money
+	sale.RequestedAt = now
+	sale.ReviewDeadline = now + reviewPeriod
+	sale.Buyer = buyerID
+	sale.Earnest = earnest
+	sale.PaymentTxId = paymentTxId
+	sale.EarnestFlag = earnestFlag
+	sale.SellerAccepted = false
+	sale.IsPendingApproval = true // Mark as pending approval
+
+	// Low-risk sales are approved right away, the others wait for the inspector
+	autoApproved, err := _runAutoApproval(ctx, sale)
+	if err != nil {
+		return false, err
+	}
+	if autoApproved {
+		err = _completeSale(ctx, sale)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = _putSale(ctx, sale)
+	if err != nil {
+		return false, wrapError(err, "failed to update sale state")
+	}
+
+	if autoApproved {
+		decision := &SaleDecision{TokenId: tokenId, Buyer: sale.Buyer, Decision: DecisionApprove, DecidedBy: autoApprover, DecidedAt: now}
+		if sale.Confidential {
+			decision.Buyer = ""
+		}
+		err = _recordDecision(ctx, decision, sale.Buyer)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// GetPendingApprovalNFTs returns all NFTs that are pending approval for sale, along with their metadata
+func (c *TokenERC721Contract) GetPendingApprovalNFTs(ctx kalpsdk.TransactionContextInterface) ([]*SaleWithMetadata, error) {
+	sales, err := _listSales(ctx)
+	if err != nil {
+		return nil, wrapError(err, "failed to get sale listings")
+	}
+
+	// Create a slice to hold the NFTs that are pending approval with their metadata
+	var pendingApprovals []*SaleWithMetadata
+
+	for _, sale := range sales {
+		// Only process if the NFT is pending approval
+		if !sale.IsPendingApproval {
+			continue
+		}
+		sale.Allowlist = sale.Allowlist.public()
+
+		// Combine Sale and NFT Metadata
+		saleWithMetadata, err := _withMetadata(ctx, sale)
+		if err != nil {
+			return nil, err
+		}
+
+		// Attach the payment confirmation so the inspector can check it before approving
+		if sale.PaymentTxId != "" {
+			payment, err := _readPaymentInfo(ctx, sale.PaymentTxId)
+			if err != nil {
+				return nil, wrapError(err, "failed to read payment info for tokenId %s", sale.TokenId)
+			}
+			saleWithMetadata.Payment = payment
+		}
+
+		// Add to the list of NFTs pending approval
+		pendingApprovals = append(pendingApprovals, saleWithMetadata)
+	}
+
+	// Return the list of NFTs pending approval with their metadata
+	return pendingApprovals, nil
+}
+
+
+// ApproveSale allows the inspector to approve or reject a sale.
+// Use DecideSale to give a rejection reason or approve on conditions.
+func (c *TokenERC721Contract) ApproveSale(ctx kalpsdk.TransactionContextInterface, tokenId string, isApproved string) (bool, error) {
+	decision := &SaleDecision{Decision: DecisionApprove}
+	if isApproved != "true" {
+		decision = &SaleDecision{Decision: DecisionReject, ReasonCode: RejectReasonUnspecified}
+	}
+
+	_, err := _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (*SaleDecision, error) {
+		return c.decideSale(ctx, tokenId, decision)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *TokenERC721Contract) decideSale(ctx kalpsdk.TransactionContextInterface, tokenId string, decision *SaleDecision) (*SaleDecision, error) {
+	// Ensure only the inspector can approve or reject the sale
+	inspectorID, err := c._requireInspector(ctx, "approve or reject the sale")
+	if err != nil {
+		return nil, err
+	}
+
+	err = _requireNotPaused(ctx, ScopeApprove)
+	if err != nil {
+		return nil, err
+	}
+
+	err = _validateDecision(decision)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch the sale information
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sale.IsOnSale {
+		return nil, newError(CodeInvalidState, "NFT is not on sale").WithDetail("tokenId", tokenId)
+	}
+	if !sale.IsPendingApproval || sale.Buyer == "" {
+		return nil, newError(CodeInvalidState, "NFT has no pending sale").WithDetail("tokenId", tokenId)
+	}
+
+	err = _requireNoOpenDispute(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	buyerID := sale.Buyer
+	decision.TokenId = tokenId
+	decision.Buyer = buyerID
+	if sale.Confidential {
+		decision.Buyer = ""
+	}
+	decision.DecidedBy = inspectorID
+	decision.DecidedAt = now
+
+	switch decision.Decision {
+	case DecisionApprove:
+		_, err = _signOff(ctx, sale, TierInspector, inspectorID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Sales above an AML threshold wait for the higher tiers
+		_, err = _advanceApproval(ctx, sale)
+		if err != nil {
+			return nil, err
+		}
+
+	case DecisionConditional:
+		_, err = _signOff(ctx, sale, TierInspector, inspectorID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The buyer gets a fresh review period to meet the conditions
+		reviewPeriod, err := _readReviewPeriod(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sale.IsApproved = DecisionConditional
+		sale.Conditions = decision.Conditions
+		sale.ReviewDeadline = now + reviewPeriod
+
+		_, err = _advanceApproval(ctx, sale)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		// Sale is rejected, return the earnest money to the buyer
+		sale.clearBuyRequest()
+		sale.IsApproved = "false"
+	}
+
+	// Update sale information in the ledger
+	err = _putSale(ctx, sale)
+	if err != nil {
+		return nil, wrapError(err, "failed to update sale state")
+	}
+
+	err = _recordDecision(ctx, decision, buyerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return decision, nil
+}
+
+// _completeSale settles an approved sale and transfers the NFT to the buyer
+func _completeSale(ctx kalpsdk.TransactionContextInterface, sale *Sale) error {
+	tokenId := sale.TokenId
+	err := _requireNotFrozen(ctx, tokenId, sale.Seller, sale.Buyer)
+	if err != nil {
+		return err
+	}
+
+	// The co-owners of a co-owned NFT accept the buy request through ConsentToAction
+	coOwnership, err := _getCoOwnership(ctx, tokenId)
+	if err != nil {
+		return err
+	}
+	if coOwnership != nil && !sale.SellerAccepted {
+		return newError(CodeInvalidState, "the co-owners have not accepted the sale").WithDetail("tokenId", tokenId)
+	}
+
+	// Past the deadline the buyer may already be reclaiming the earnest money
+	expired, err := _reviewExpired(ctx, sale)
+	if err != nil {
+		return err
+	}
+	if expired {
+		return newError(CodeInvalidState, "the review deadline has passed").WithDetail("reviewDeadline", sale.ReviewDeadline)
+	}
+
+	// Approve the sale and transfer the NFT to the buyer
+	sale.IsApproved = "true"
+	sale.IsOnSale = false
+	sale.IsPendingApproval = false // Reset pending approval
+
+	// Get the current NFT data
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return wrapError(err, "failed to read NFT")
+	}
+
+	// Record the split of the sale proceeds between seller, royalty receiver and platform
+	settlement, err := _recordSettlement(ctx, sale, nft)
+	if err != nil {
+		return wrapError(err, "failed to record settlement")
+	}
+	sale.SettlementTxId = settlement.TxId
+	sale.CompletedAt, err = _txUnixTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	// End the lease if the listing said it does not survive the sale
+	var endedLease *Lease
+	if !sale.LeaseSurvivesSale {
+		endedLease, err = _endLease(ctx, tokenId)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Transfer ownership of the NFT to the buyer
+	return _transferNFTEndingLease(ctx, nft, sale.Buyer, endedLease)
+}
+
+func (c *TokenERC721Contract) OwnerOf(ctx kalpsdk.TransactionContextInterface, tokenId string) (string, error) {
+
+	// Check if contract has been intilized first
+	initialized, err := checkInitialized(ctx)
+	if err != nil {
+		return "", wrapError(err, "failed to check if contract is already initialized")
+	}
+	if !initialized {
+		return "", newError(CodeInvalidState, "contract options need to be set before calling any function, call Initialize() to initialize contract")
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return "", wrapError(err, "could not process OwnerOf for tokenId %s", tokenId)
+	}
+
+	return nft.Owner, nil
+}
+
+// Name returns a descriptive name for a collection of non-fungible tokens in this contract
+// returns {String} Returns the name of the token
+
+func (c *TokenERC721Contract) Name(ctx kalpsdk.TransactionContextInterface) (string, error) {
+
+	// Check if contract has been intilized first
+	initialized, err := checkInitialized(ctx)
+	if err != nil {
+		return "", wrapError(err, "failed to check if contract is already initialized")
+	}
+	if !initialized {
+		return "", newError(CodeInvalidState, "contract options need to be set before calling any function, call Initialize() to initialize contract")
+	}
+
+	bytes, err := ctx.GetState(nameKey)
+	if err != nil {
+		return "", wrapError(err, "failed to get Name bytes")
+	}
+
+	return string(bytes), nil
+}
+
+// Symbol returns an abbreviated name for non-fungible tokens in this contract.
+// returns {String} Returns the symbol of the token
+
+func (c *TokenERC721Contract) Symbol(ctx kalpsdk.TransactionContextInterface) (string, error) {
+
+	// Check if contract has been intilized first
+	initialized, err := checkInitialized(ctx)
+	if err != nil {
+		return "", wrapError(err, "failed to check if contract is already initialized")
+	}
+	if !initialized {
+		return "", newError(CodeInvalidState, "contract options need to be set before calling any function, call Initialize() to initialize contract")
+	}
+
+	bytes, err := ctx.GetState(symbolKey)
+	if err != nil {
+		return "", wrapError(err, "failed to get Symbol")
+	}
+
+	return string(bytes), nil
+}
+
+// GetNFTMetadata retrieves the complete NFT metadata by tokenId
+func (c *TokenERC721Contract) GetNFTMetadata(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Nft, error) {
+
+	// Check if the contract has been initialized
+	initialized, err := checkInitialized(ctx)
+	if err != nil {
+		return nil, wrapError(err, "failed to check if contract is already initialized")
+	}
+	if !initialized {
+		return nil, newError(CodeInvalidState, "contract options need to be set before calling any function, call Initialize() to initialize the contract")
+	}
+
+	// Read the NFT from the state
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return nil, wrapError(err, "failed to read NFT for tokenId %s", tokenId)
+	}
+
+	// Include the collateral pledge, so lenders and buyers can see the NFT is locked
+	nft.Pledge, _, err = _getPledge(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the complete NFT metadata, including the TokenURI
+	return nft, nil
+}
+
+
+// Checks that contract options have been already initialized
+func checkInitialized(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+	initialized, err := _keyExists(ctx, nameKey)
+	if err != nil {
+		return false, wrapError(err, "failed to get token name")
+	}
+	return initialized, nil
+}
\ No newline at end of file
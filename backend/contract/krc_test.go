@@ -0,0 +1,1865 @@
+package contract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"krc20/mockledger"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+const (
+	testDeployer = "deployer"
+	testBuyer    = "buyer"
+	testStranger = "stranger"
+
+	testPaymentEngine = "payment-engine"
+)
+
+// Gateway payment confirmation accepted by the payment engine configured in the tests
+const testPayment = `{"paymentTransactionId":"bank-1","paymentGatewayName":"bank","paymentMetaData":{"amount":1000,"currencyCode":"USD","applicationReferenceId":"fake-payment-engine"}}`
+
+// testEnv is a contract running against an in-memory ledger
+type testEnv struct {
+	t        *testing.T
+	ledger   *mockledger.Ledger
+	contract *TokenERC721Contract
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	env := &testEnv{
+		t:        t,
+		ledger:   mockledger.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		contract: &TokenERC721Contract{kalpsdk.Contract{}, testDeployer},
+	}
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.Initialize(ctx, "EstateX", "ESX", inspectorAddress)
+		return err
+	})
+	return env
+}
+
+func (env *testEnv) submit(userID string, fn func(ctx kalpsdk.TransactionContextInterface) error) error {
+	return env.ledger.Submit(userID, fn)
+}
+
+func (env *testEnv) mustSubmit(userID string, fn func(ctx kalpsdk.TransactionContextInterface) error) {
+	env.t.Helper()
+	if err := env.submit(userID, fn); err != nil {
+		env.t.Fatalf("transaction by %s failed: %v", userID, err)
+	}
+}
+
+func (env *testEnv) ctx(userID string) kalpsdk.TransactionContextInterface {
+	return env.ledger.NewTransaction(userID)
+}
+
+func (env *testEnv) mint() string {
+	env.t.Helper()
+	var tokenId string
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		nft, err := env.contract.MintWithTokenURIWithDetails(ctx, "", "Villa", "1 Main St", "Sea view", "ipfs://villa",
+			"House", 3, 2, 1800, 1999, "architect", 500)
+		if err != nil {
+			return err
+		}
+		tokenId = nft.TokenId
+		return nil
+	})
+	return tokenId
+}
+
+func (env *testEnv) list(tokenId string, price int) {
+	env.t.Helper()
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ListNFTForSale(ctx, tokenId, price)
+		return err
+	})
+}
+
+func (env *testEnv) buy(tokenId string, earnest int) {
+	env.t.Helper()
+	env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.BuyNFT(ctx, tokenId, earnest)
+		return err
+	})
+}
+
+func (env *testEnv) sale(tokenId string) *Sale {
+	env.t.Helper()
+	sale, err := _readSale(env.ctx(testStranger), tokenId)
+	if err != nil {
+		env.t.Fatalf("failed to read sale %s: %v", tokenId, err)
+	}
+	return sale
+}
+
+func (env *testEnv) nft(tokenId string) *Nft {
+	env.t.Helper()
+	nft, err := _readNFT(env.ctx(testStranger), tokenId)
+	if err != nil {
+		env.t.Fatalf("failed to read NFT %s: %v", tokenId, err)
+	}
+	return nft
+}
+
+func wantCode(t *testing.T, err error, code ErrorCode) {
+	t.Helper()
+	if code == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if !IsErrorCode(err, code) {
+		t.Fatalf("error = %v, want code %s", err, code)
+	}
+}
+
+// mentions reports whether any string in the JSON document equals s
+func mentions(document []byte, s string) bool {
+	var value interface{}
+	if json.Unmarshal(document, &value) != nil {
+		return false
+	}
+
+	var walk func(value interface{}) bool
+	walk = func(value interface{}) bool {
+		switch v := value.(type) {
+		case string:
+			return v == s
+		case []interface{}:
+			for _, item := range v {
+				if walk(item) {
+					return true
+				}
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				if walk(item) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return walk(value)
+}
+
+func TestMarketplaceFlows(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(env *testEnv) string
+		user  string
+		call  func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error
+		code  ErrorCode
+		check func(t *testing.T, env *testEnv, tokenId string)
+	}{
+		{
+			name: "mint assigns the next token id to the deployer",
+			setup: func(env *testEnv) string {
+				return env.mint()
+			},
+			user: testDeployer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.MintWithTokenURIWithDetails(ctx, "", "Loft", "2 Main St", "", "", "Apartment", 1, 1, 700, 2010, "", 0)
+				return err
+			},
+			check: func(t *testing.T, env *testEnv, tokenId string) {
+				nft := env.nft("2")
+				if nft.Owner != testDeployer || nft.TokenURI.Name != "Loft" {
+					t.Errorf("minted NFT = %+v", nft)
+				}
+				events := env.ledger.Events()
+				last := events[len(events)-1]
+				if last.Name != "Transfer" {
+					t.Errorf("last event = %s, want Transfer", last.Name)
+				}
+			},
+		},
+		{
+			name: "mint by another user is rejected",
+			user: testStranger,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.MintWithTokenURIWithDetails(ctx, "", "Loft", "", "", "", "", 0, 0, 0, 0, "", 0)
+				return err
+			},
+			code: CodeUnauthorized,
+		},
+		{
+			name: "mint rejects royalties above 100%",
+			user: testDeployer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.MintWithTokenURIWithDetails(ctx, "", "Loft", "", "", "", "", 0, 0, 0, 0, "architect", 10001)
+				return err
+			},
+			code: CodeValidation,
+		},
+		{
+			name: "list puts the NFT on sale",
+			setup: func(env *testEnv) string {
+				return env.mint()
+			},
+			user: testDeployer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.ListNFTForSale(ctx, tokenId, 1000)
+				return err
+			},
+			check: func(t *testing.T, env *testEnv, tokenId string) {
+				sale := env.sale(tokenId)
+				if !sale.IsOnSale || sale.Price != 1000 || sale.Seller != testDeployer {
+					t.Errorf("sale = %+v", sale)
+				}
+			},
+		},
+		{
+			name: "list by a non-owner is rejected",
+			setup: func(env *testEnv) string {
+				return env.mint()
+			},
+			user: testStranger,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.ListNFTForSale(ctx, tokenId, 1000)
+				return err
+			},
+			code: CodeUnauthorized,
+		},
+		{
+			name: "list of an unminted token is not found",
+			user: testDeployer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.ListNFTForSale(ctx, "99", 1000)
+				return err
+			},
+			code: CodeNotFound,
+		},
+		{
+			name: "buy marks the sale pending approval",
+			setup: func(env *testEnv) string {
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				return tokenId
+			},
+			user: testBuyer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.BuyNFT(ctx, tokenId, 1200)
+				return err
+			},
+			check: func(t *testing.T, env *testEnv, tokenId string) {
+				sale := env.sale(tokenId)
+				if !sale.IsPendingApproval || sale.Buyer != testBuyer || sale.Earnest != 1200 {
+					t.Errorf("sale = %+v", sale)
+				}
+			},
+		},
+		{
+			name: "buy below the asking price is rejected",
+			setup: func(env *testEnv) string {
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				return tokenId
+			},
+			user: testBuyer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.BuyNFT(ctx, tokenId, 999)
+				return err
+			},
+			code: CodeValidation,
+		},
+		{
+			name: "buy of an unlisted NFT is not found",
+			setup: func(env *testEnv) string {
+				return env.mint()
+			},
+			user: testBuyer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.BuyNFT(ctx, tokenId, 1000)
+				return err
+			},
+			code: CodeNotFound,
+		},
+		{
+			name: "approve transfers the NFT and records the settlement",
+			setup: func(env *testEnv) string {
+				env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+					_, err := env.contract.SetPlatformFee(ctx, "platform", 250)
+					return err
+				})
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				env.buy(tokenId, 2000)
+				return tokenId
+			},
+			user: inspectorAddress,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.ApproveSale(ctx, tokenId, "true")
+				return err
+			},
+			check: func(t *testing.T, env *testEnv, tokenId string) {
+				if owner := env.nft(tokenId).Owner; owner != testBuyer {
+					t.Errorf("owner = %s, want %s", owner, testBuyer)
+				}
+				sale := env.sale(tokenId)
+				if sale.IsOnSale || sale.IsPendingApproval || sale.IsApproved != "true" {
+					t.Errorf("sale = %+v", sale)
+				}
+				settlements, err := env.contract.GetSettlements(env.ctx(testStranger), tokenId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := Settlement{SalePrice: 2000, RoyaltyAmount: 100, PlatformFeeAmount: 50, SellerProceeds: 1850}
+				if len(settlements) != 1 || settlements[0].SalePrice != want.SalePrice || settlements[0].RoyaltyAmount != want.RoyaltyAmount ||
+					settlements[0].PlatformFeeAmount != want.PlatformFeeAmount || settlements[0].SellerProceeds != want.SellerProceeds {
+					t.Errorf("settlements = %+v, want one with %+v", settlements, want)
+				}
+				var transfer Transfer
+				events := env.ledger.Events()
+				if err := json.Unmarshal(events[len(events)-1].Payload, &transfer); err != nil {
+					t.Fatal(err)
+				}
+				if transfer.From != testDeployer || transfer.To != testBuyer {
+					t.Errorf("transfer event = %+v", transfer)
+				}
+			},
+		},
+		{
+			name: "reject relists the NFT and clears the buyer",
+			setup: func(env *testEnv) string {
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				env.buy(tokenId, 1000)
+				return tokenId
+			},
+			user: inspectorAddress,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.ApproveSale(ctx, tokenId, "false")
+				return err
+			},
+			check: func(t *testing.T, env *testEnv, tokenId string) {
+				if owner := env.nft(tokenId).Owner; owner != testDeployer {
+					t.Errorf("owner = %s, want %s", owner, testDeployer)
+				}
+				sale := env.sale(tokenId)
+				if !sale.IsOnSale || sale.IsPendingApproval || sale.Buyer != "" || sale.Earnest != 0 || sale.IsApproved != "false" {
+					t.Errorf("sale = %+v", sale)
+				}
+			},
+		},
+		{
+			name: "approve by another user is rejected",
+			setup: func(env *testEnv) string {
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				env.buy(tokenId, 1000)
+				return tokenId
+			},
+			user: testBuyer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.ApproveSale(ctx, tokenId, "true")
+				return err
+			},
+			code: CodeUnauthorized,
+		},
+		{
+			name: "fiat purchase links the payment confirmation to the sale",
+			setup: func(env *testEnv) string {
+				env.ledger.SetKYC(testBuyer, true)
+				env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+					_, err := env.contract.SetPaymentEngine(ctx, "fake-payment-engine", testPaymentEngine)
+					return err
+				})
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				return tokenId
+			},
+			user: testPaymentEngine,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.BuyNFTWithPayment(ctx, tokenId, testBuyer, testPayment)
+				return err
+			},
+			check: func(t *testing.T, env *testEnv, tokenId string) {
+				pending, err := env.contract.GetPendingApprovalNFTs(env.ctx(inspectorAddress))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(pending) != 1 || pending[0].Payment == nil || pending[0].Payment.DocType != paymentInfoDocType {
+					t.Fatalf("pending approvals = %+v", pending)
+				}
+				if pending[0].Sale.Earnest != 1000 || pending[0].Payment.AssetId != tokenId {
+					t.Errorf("pending approval = %+v", pending[0])
+				}
+			},
+		},
+		{
+			name: "fiat purchase from an unknown payment engine is rejected",
+			setup: func(env *testEnv) string {
+				env.ledger.SetKYC(testBuyer, true)
+				env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+					_, err := env.contract.SetPaymentEngine(ctx, "fake-payment-engine", testPaymentEngine)
+					return err
+				})
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				return tokenId
+			},
+			user: testPaymentEngine,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				payment := `{"paymentTransactionId":"bank-1","paymentMetaData":{"amount":1000,"currencyCode":"USD","applicationReferenceId":"other-engine"}}`
+				_, err := env.contract.BuyNFTWithPayment(ctx, tokenId, testBuyer, payment)
+				return err
+			},
+			code: CodeValidation,
+		},
+		{
+			name: "fiat purchase of a fractional amount is rejected",
+			setup: func(env *testEnv) string {
+				env.ledger.SetKYC(testBuyer, true)
+				env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+					_, err := env.contract.SetPaymentEngine(ctx, "fake-payment-engine", testPaymentEngine)
+					return err
+				})
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				return tokenId
+			},
+			user: testPaymentEngine,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				payment := `{"paymentTransactionId":"bank-1","paymentMetaData":{"amount":1000.75,"currencyCode":"USD","applicationReferenceId":"fake-payment-engine"}}`
+				_, err := env.contract.BuyNFTWithPayment(ctx, tokenId, testBuyer, payment)
+				return err
+			},
+			code: CodeValidation,
+		},
+		{
+			name: "fiat purchase submitted by the buyer is rejected",
+			setup: func(env *testEnv) string {
+				env.ledger.SetKYC(testBuyer, true)
+				env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+					_, err := env.contract.SetPaymentEngine(ctx, "fake-payment-engine", testPaymentEngine)
+					return err
+				})
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				return tokenId
+			},
+			user: testBuyer,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.BuyNFTWithPayment(ctx, tokenId, testBuyer, testPayment)
+				return err
+			},
+			code: CodeUnauthorized,
+		},
+		{
+			name: "gateway payment cannot fund a second purchase",
+			setup: func(env *testEnv) string {
+				env.ledger.SetKYC(testBuyer, true)
+				env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+					_, err := env.contract.SetPaymentEngine(ctx, "fake-payment-engine", testPaymentEngine)
+					return err
+				})
+				paid := env.mint()
+				env.list(paid, 1000)
+				env.mustSubmit(testPaymentEngine, func(ctx kalpsdk.TransactionContextInterface) error {
+					_, err := env.contract.BuyNFTWithPayment(ctx, paid, testBuyer, testPayment)
+					return err
+				})
+				tokenId := env.mint()
+				env.list(tokenId, 1000)
+				return tokenId
+			},
+			user: testPaymentEngine,
+			call: func(env *testEnv, ctx kalpsdk.TransactionContextInterface, tokenId string) error {
+				_, err := env.contract.BuyNFTWithPayment(ctx, tokenId, testBuyer, testPayment)
+				return err
+			},
+			code: CodeConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			var tokenId string
+			if tt.setup != nil {
+				tokenId = tt.setup(env)
+			}
+
+			err := env.submit(tt.user, func(ctx kalpsdk.TransactionContextInterface) error {
+				return tt.call(env, ctx, tokenId)
+			})
+			wantCode(t, err, tt.code)
+
+			if tt.check != nil {
+				tt.check(t, env, tokenId)
+			}
+		})
+	}
+}
+
+func TestMintBeforeInitialize(t *testing.T) {
+	ledger := mockledger.New(time.Unix(0, 0))
+	contract := &TokenERC721Contract{kalpsdk.Contract{}, testDeployer}
+
+	_, err := contract.MintWithTokenURIWithDetails(ledger.NewTransaction(testDeployer), "", "Villa", "", "", "", "", 0, 0, 0, 0, "", 0)
+	wantCode(t, err, CodeInvalidState)
+}
+
+func TestStateCacheReadsOwnWrites(t *testing.T) {
+	ledger := mockledger.New(time.Unix(0, 0))
+	tx := ledger.NewTransaction(testDeployer)
+
+	_, err := _withStateCache(tx, func(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+		if err := _putJSON(ctx, tokenCounterKey, 7); err != nil {
+			return false, err
+		}
+		counter := 0
+		if _, err := _getJSON(ctx, tokenCounterKey, &counter); err != nil || counter != 7 {
+			t.Errorf("read after write = %d, %v", counter, err)
+		}
+
+		key, _ := ctx.CreateCompositeKey(nftPrefix, []string{"1"})
+		if err := _putJSON(ctx, key, Nft{TokenId: "1"}); err != nil {
+			return false, err
+		}
+		nfts, err := _listJSON[Nft](ctx, nftPrefix)
+		if err != nil || len(nfts) != 1 {
+			t.Errorf("iterator over buffered writes = %v, %v", nfts, err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := tx.WrittenKeys(); len(keys) != 2 {
+		t.Errorf("flushed keys = %q, want 2 keys", keys)
+	}
+}
+
+func TestExportImportState(t *testing.T) {
+	source := newTestEnv(t)
+	tokenId := source.mint()
+	source.list(tokenId, 1000)
+	source.mint()
+
+	// Encumbrances and private sale terms are carried over as well
+	source.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := source.contract.RegisterLien(ctx, tokenId, "bank", 500, "sha256:mortgage")
+		return err
+	})
+	confidential := source.mint()
+	tx := source.ledger.NewTransaction(testDeployer)
+	tx.SetTransient(map[string][]byte{saleTermsTransientKey: []byte(`{"price":1000,"salt":"f3a1"}`)})
+	if _, err := source.contract.ListNFTForConfidentialSale(tx, confidential); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.ledger.Commit(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestEnv(t)
+	for _, prefix := range SnapshotPrefixes() {
+		bookmark := ""
+		for {
+			var page *StatePage
+			source.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) (err error) {
+				page, err = source.contract.ExportState(ctx, prefix, bookmark)
+				return err
+			})
+			batch, _ := json.Marshal(page)
+			target.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+				_, err := target.contract.ImportState(ctx, string(batch))
+				return err
+			})
+			if bookmark = page.Bookmark; bookmark == "" {
+				break
+			}
+		}
+	}
+
+	if owner := target.nft("2").Owner; owner != testDeployer {
+		t.Errorf("imported owner = %s, want %s", owner, testDeployer)
+	}
+	if sale := target.sale(tokenId); !sale.IsOnSale || sale.Price != 1000 {
+		t.Errorf("imported sale = %+v", sale)
+	}
+	for _, key := range source.ledger.Keys() {
+		if key != nameKey && key != symbolKey && key != contractConfigKey && key != marketplaceOpenKey && key != schemaVersionKey &&
+			string(target.ledger.Get(key)) != string(source.ledger.Get(key)) {
+			t.Errorf("key %q was not migrated", key)
+		}
+	}
+	for _, key := range source.ledger.PrivateKeys(saleTermsCollection) {
+		if string(target.ledger.GetPrivate(saleTermsCollection, key)) != string(source.ledger.GetPrivate(saleTermsCollection, key)) {
+			t.Errorf("private key %q was not migrated", key)
+		}
+	}
+	if sale := target.sale(confidential); sale.Price != 1000 {
+		t.Errorf("imported confidential sale = %+v", sale)
+	}
+
+	var page *StatePage
+	source.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) (err error) {
+		page, err = source.contract.ExportState(ctx, nftPrefix, "")
+		return err
+	})
+	batch, _ := json.Marshal(page)
+
+	// Keys imported once cannot be overwritten
+	err := target.submit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := target.contract.ImportState(ctx, string(batch))
+		return err
+	})
+	wantCode(t, err, CodeConflict)
+
+	page.Entries[0].Value = []byte(`{"tokenId":"1","owner":"stranger"}`)
+	tampered, _ := json.Marshal(page)
+	err = newTestEnv(t).submit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := target.contract.ImportState(ctx, string(tampered))
+		return err
+	})
+	wantCode(t, err, CodeValidation)
+
+	// Minting on the target opens the marketplace and closes the import window
+	target.mint()
+	err = target.submit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := target.contract.ImportState(ctx, string(batch))
+		return err
+	})
+	wantCode(t, err, CodeInvalidState)
+
+	_, err = target.contract.ExportState(target.ctx(testStranger), nftPrefix, "")
+	wantCode(t, err, CodeUnauthorized)
+}
+
+func TestRunMigrations(t *testing.T) {
+	env := newTestEnv(t)
+	tx := env.ctx(testDeployer)
+	for _, tokenId := range []string{"1", "2"} {
+		nftKey, _ := tx.CreateCompositeKey(nftPrefix, []string{tokenId})
+		env.ledger.Put(nftKey, []byte(`{"tokenId":"`+tokenId+`","owner":"deployer"}`))
+	}
+	saleKey, _ := tx.CreateCompositeKey(salePrefix, []string{"1"})
+	env.ledger.Put(saleKey, []byte(`{"tokenId":"1","seller":"deployer","price":10,"isOnSale":true}`))
+
+	// Readers upgrade old records in memory
+	if nft := env.nft("1"); nft.Version != latestSchemaVersion() {
+		t.Errorf("read NFT version = %d, want %d", nft.Version, latestSchemaVersion())
+	}
+
+	var progress *MigrationProgress
+	bookmark := ""
+	batches := 0
+	for {
+		env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) (err error) {
+			progress, err = env.contract.RunMigrations(ctx, 2, bookmark)
+			return err
+		})
+		batches++
+		if bookmark = progress.Bookmark; bookmark == "" {
+			break
+		}
+	}
+
+	if batches != 2 || progress.SchemaVersion != latestSchemaVersion() {
+		t.Errorf("after %d batches progress = %+v", batches, progress)
+	}
+	var stored map[string]interface{}
+	if err := json.Unmarshal(env.ledger.Get(saleKey), &stored); err != nil || stored["version"] != float64(latestSchemaVersion()) {
+		t.Errorf("stored sale = %v, %v", stored, err)
+	}
+	if version, err := env.contract.GetSchemaVersion(env.ctx(testStranger)); err != nil || version != latestSchemaVersion() {
+		t.Errorf("schema version = %d, %v", version, err)
+	}
+
+	_, err := env.contract.RunMigrations(env.ctx(testStranger), 10, "")
+	wantCode(t, err, CodeUnauthorized)
+}
+
+func TestInitializeAndAdminHandover(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.contract.Initialize(env.ctx(testDeployer), "Other", "OTH", testStranger)
+	wantCode(t, err, CodeConflict)
+	_, err = env.contract.Initialize(env.ctx(testStranger), "Other", "OTH", testStranger)
+	wantCode(t, err, CodeUnauthorized)
+
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.TransferAdmin(ctx, testBuyer)
+		return err
+	})
+
+	// The old admin keeps the role until the handover is accepted
+	_, err = env.contract.AcceptAdmin(env.ctx(testStranger))
+	wantCode(t, err, CodeUnauthorized)
+	env.mint()
+
+	env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.AcceptAdmin(ctx)
+		return err
+	})
+
+	config, err := env.contract.GetContractConfig(env.ctx(testStranger))
+	if err != nil || config.Admin != testBuyer || config.PendingAdmin != "" || config.Inspector != inspectorAddress {
+		t.Errorf("config = %+v, %v", config, err)
+	}
+	_, err = env.contract.SetPlatformFee(env.ctx(testDeployer), testDeployer, 100)
+	wantCode(t, err, CodeUnauthorized)
+	_, err = env.contract.SetPlatformFee(env.ctx(testBuyer), testBuyer, 100)
+	wantCode(t, err, "")
+}
+
+func TestPauseScopes(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.GrantRole(ctx, pauserRole, testStranger)
+		return err
+	})
+	tokenId := env.mint()
+	env.list(tokenId, 1000)
+
+	pause := func(scope string) error {
+		return env.submit(testStranger, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.Pause(ctx, scope)
+			return err
+		})
+	}
+	wantCode(t, pause(ScopeBuy), "")
+	wantCode(t, pause(ScopeBuy), CodeConflict)
+	wantCode(t, pause("everything"), CodeValidation)
+
+	_, err := env.contract.BuyNFT(env.ctx(testBuyer), tokenId, 1000)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.ListNFTForSale(env.ctx(testDeployer), tokenId, 900)
+	wantCode(t, err, "")
+
+	statuses, err := env.contract.GetPauseStatus(env.ctx(testBuyer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Paused != (status.Scope == ScopeBuy) {
+			t.Errorf("status = %+v", status)
+		}
+	}
+
+	env.mustSubmit(testStranger, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.Unpause(ctx, ScopeBuy)
+		return err
+	})
+	if events := env.ledger.Events(); events[len(events)-1].Name != "Unpaused" {
+		t.Errorf("last event = %s, want Unpaused", events[len(events)-1].Name)
+	}
+	env.buy(tokenId, 1000)
+
+	// Pausing transfers blocks the approval that would move the NFT, and pledging or leasing it
+	wantCode(t, pause(ScopeTransfer), "")
+	_, err = env.contract.ApproveSale(env.ctx(inspectorAddress), tokenId, "true")
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.PledgeCollateral(env.ctx(testDeployer), tokenId, testStranger, `{"principal":500}`)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.SetUser(env.ctx(testDeployer), tokenId, testStranger, env.ledger.Now().Unix()+3600)
+	wantCode(t, err, CodeInvalidState)
+
+	wantCode(t, pause(ScopeAll), "")
+	_, err = env.contract.MintWithTokenURIWithDetails(env.ctx(testDeployer), "", "Loft", "", "", "", "", 0, 0, 0, 0, "", 0)
+	wantCode(t, err, CodeInvalidState)
+
+	_, err = env.contract.Pause(env.ctx(testDeployer), ScopeMint)
+	wantCode(t, err, CodeUnauthorized)
+}
+
+func TestFreezeTokensAndAccounts(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.GrantRole(ctx, complianceRole, testStranger)
+		return err
+	})
+	tokenId := env.mint()
+	env.list(tokenId, 1000)
+
+	env.mustSubmit(testStranger, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.FreezeToken(ctx, tokenId, "court order 42")
+		return err
+	})
+	_, err := env.contract.BuyNFT(env.ctx(testBuyer), tokenId, 1000)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.FreezeToken(env.ctx(testStranger), tokenId, "again")
+	wantCode(t, err, CodeConflict)
+	_, err = env.contract.FreezeAccount(env.ctx(testBuyer), testDeployer, "no role")
+	wantCode(t, err, CodeUnauthorized)
+
+	env.ledger.Advance(time.Hour)
+	env.mustSubmit(testStranger, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.UnfreezeToken(ctx, tokenId, "order lifted")
+		return err
+	})
+	env.buy(tokenId, 1000)
+
+	// A frozen buyer blocks the approval of their pending purchase
+	env.mustSubmit(testStranger, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.FreezeAccount(ctx, testBuyer, "sanctions screening")
+		return err
+	})
+	_, err = env.contract.ApproveSale(env.ctx(inspectorAddress), tokenId, "true")
+	wantCode(t, err, CodeInvalidState)
+
+	status, err := env.contract.GetAccountFreeze(env.ctx(testDeployer), testBuyer)
+	if err != nil || !status.IsFrozen || status.Freeze.Reason != "sanctions screening" {
+		t.Errorf("account freeze = %+v, %v", status, err)
+	}
+	history, err := env.contract.GetFreezeHistory(env.ctx(testDeployer), FreezeTargetToken, tokenId)
+	if err != nil || len(history) != 2 || history[0].Action != "freeze" || history[1].Reason != "order lifted" {
+		t.Errorf("token freeze history = %+v, %v", history, err)
+	}
+}
+
+func TestLiensBlockTransfer(t *testing.T) {
+	const lender = "lender"
+	env := newTestEnv(t)
+	tokenId := env.mint()
+
+	var lien *Lien
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) (err error) {
+		lien, err = env.contract.RegisterLien(ctx, tokenId, lender, 500000, "sha256:deed")
+		return err
+	})
+	_, err := env.contract.RegisterLien(env.ctx(testStranger), tokenId, lender, 1, "sha256:x")
+	wantCode(t, err, CodeUnauthorized)
+
+	env.list(tokenId, 1000)
+	env.buy(tokenId, 1000)
+
+	approve := func() error {
+		return env.submit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.ApproveSale(ctx, tokenId, "true")
+			return err
+		})
+	}
+	wantCode(t, approve(), CodeInvalidState)
+
+	_, err = env.contract.ReleaseLien(env.ctx(testStranger), tokenId, lien.LienId)
+	wantCode(t, err, CodeUnauthorized)
+	_, err = env.contract.SignOffLienForSale(env.ctx(testDeployer), tokenId, lien.LienId)
+	wantCode(t, err, CodeUnauthorized)
+
+	// The holder's sign-off lets this sale settle with the lien still registered
+	env.mustSubmit(lender, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.SignOffLienForSale(ctx, tokenId, lien.LienId)
+		return err
+	})
+	wantCode(t, approve(), "")
+	if owner := env.nft(tokenId).Owner; owner != testBuyer {
+		t.Errorf("owner = %s, want %s", owner, testBuyer)
+	}
+
+	liens, err := env.contract.GetLiens(env.ctx(testBuyer), tokenId)
+	if err != nil || len(liens) != 1 || liens[0].Released || liens[0].SignedOffFor != "" {
+		t.Fatalf("liens = %+v, %v", liens, err)
+	}
+
+	env.mustSubmit(lender, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ReleaseLien(ctx, tokenId, lien.LienId)
+		return err
+	})
+	_, err = env.contract.ReleaseLien(env.ctx(testDeployer), tokenId, lien.LienId)
+	wantCode(t, err, CodeInvalidState)
+}
+
+func TestCollateralPledgeAndForeclosure(t *testing.T) {
+	const lender = "lender"
+	env := newTestEnv(t)
+	tokenId := env.mint()
+
+	maturity := env.ledger.Now().Add(90 * 24 * time.Hour).Unix()
+	_, err := env.contract.PledgeCollateral(env.ctx(testDeployer), tokenId, lender,
+		fmt.Sprintf(`{"principal":400000,"maturityDate":%d,"gracePeriodDays":0}`, maturity))
+	wantCode(t, err, CodeValidation)
+	_, err = env.contract.PledgeCollateral(env.ctx(testDeployer), tokenId, lender, `{"principal":400000,"gracePeriodDays":30}`)
+	wantCode(t, err, CodeValidation)
+
+	terms := fmt.Sprintf(`{"principal":400000,"interestBps":650,"maturityDate":%d,"gracePeriodDays":30}`, maturity)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.PledgeCollateral(ctx, tokenId, lender, terms)
+		return err
+	})
+	_, err = env.contract.PledgeCollateral(env.ctx(testDeployer), tokenId, lender, terms)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.ListNFTForSale(env.ctx(testDeployer), tokenId, 1000)
+	wantCode(t, err, CodeInvalidState)
+
+	nft, err := env.contract.GetNFTMetadata(env.ctx(testStranger), tokenId)
+	if err != nil || nft.Pledge == nil || nft.Pledge.Lender != lender {
+		t.Fatalf("metadata = %+v, %v", nft, err)
+	}
+
+	foreclose := func() error {
+		return env.submit(lender, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.Foreclose(ctx, tokenId)
+			return err
+		})
+	}
+	wantCode(t, foreclose(), CodeInvalidState)
+
+	_, err = env.contract.DeclareDefault(env.ctx(testDeployer), tokenId)
+	wantCode(t, err, CodeUnauthorized)
+
+	// The lender can only declare a default once the loan is due
+	_, err = env.contract.DeclareDefault(env.ctx(lender), tokenId)
+	wantCode(t, err, CodeInvalidState)
+	env.ledger.Advance(90 * 24 * time.Hour)
+	env.mustSubmit(lender, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.DeclareDefault(ctx, tokenId)
+		return err
+	})
+
+	env.ledger.Advance(29 * 24 * time.Hour)
+	wantCode(t, foreclose(), CodeInvalidState)
+	env.ledger.Advance(24 * time.Hour)
+	wantCode(t, foreclose(), "")
+
+	nft, err = env.contract.GetNFTMetadata(env.ctx(testStranger), tokenId)
+	if err != nil || nft.Owner != lender || nft.Pledge != nil {
+		t.Errorf("metadata after foreclosure = %+v, %v", nft, err)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	const tenant = "tenant"
+	env := newTestEnv(t)
+	expires := env.ledger.Now().Add(365 * 24 * time.Hour).Unix()
+
+	setUser := func(tokenId string) {
+		env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.SetUser(ctx, tokenId, tenant, expires)
+			return err
+		})
+	}
+	sell := func(tokenId string, leaseSurvivesSale bool) {
+		env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.ListNFTForSaleWithLease(ctx, tokenId, 1000, leaseSurvivesSale)
+			return err
+		})
+		env.buy(tokenId, 1000)
+		env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.ApproveSale(ctx, tokenId, "true")
+			return err
+		})
+	}
+
+	kept := env.mint()
+	setUser(kept)
+	if events := env.ledger.Events(); events[len(events)-1].Name != "UpdateUser" {
+		t.Errorf("last event = %s, want UpdateUser", events[len(events)-1].Name)
+	}
+	_, err := env.contract.SetUser(env.ctx(testStranger), kept, testStranger, expires)
+	wantCode(t, err, CodeUnauthorized)
+	sell(kept, true)
+	if user, err := env.contract.UserOf(env.ctx(testStranger), kept); err != nil || user != tenant {
+		t.Errorf("user after sale with lease = %q, %v", user, err)
+	}
+
+	ended := env.mint()
+	setUser(ended)
+	sell(ended, false)
+	if user, err := env.contract.UserOf(env.ctx(testStranger), ended); err != nil || user != "" {
+		t.Errorf("user after sale without lease = %q, %v", user, err)
+	}
+	events := env.ledger.Events()
+	var transfer Transfer
+	if last := events[len(events)-1]; last.Name != "Transfer" || json.Unmarshal(last.Payload, &transfer) != nil ||
+		transfer.EndedLease == nil || transfer.EndedLease.User != tenant {
+		t.Errorf("last event = %s %s, want Transfer with the ended lease", last.Name, last.Payload)
+	}
+
+	// The lease lapses at its expiry without any transaction
+	env.ledger.Advance(366 * 24 * time.Hour)
+	if user, err := env.contract.UserOf(env.ctx(testStranger), kept); err != nil || user != "" {
+		t.Errorf("user after expiry = %q, %v", user, err)
+	}
+	if got, err := env.contract.UserExpires(env.ctx(testStranger), kept); err != nil || got != expires {
+		t.Errorf("expires = %d, %v, want %d", got, err, expires)
+	}
+}
+
+func TestAppraisalPriceBand(t *testing.T) {
+	env := newTestEnv(t)
+	tokenId := env.mint()
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.GrantRole(ctx, appraiserRole, testStranger)
+		return err
+	})
+	for _, value := range []int{900, 1000} {
+		env.ledger.Advance(24 * time.Hour)
+		env.mustSubmit(testStranger, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.RecordAppraisal(ctx, tokenId, value, "APR-7", "sha256:report")
+			return err
+		})
+	}
+	_, err := env.contract.RecordAppraisal(env.ctx(testBuyer), tokenId, 1, "APR-7", "sha256:report")
+	wantCode(t, err, CodeUnauthorized)
+
+	appraisals, err := env.contract.GetAppraisals(env.ctx(testBuyer), tokenId)
+	if err != nil || len(appraisals) != 2 || appraisals[1].Value != 1000 {
+		t.Fatalf("appraisals = %+v, %v", appraisals, err)
+	}
+
+	setPolicy := func(mode string) {
+		env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.SetPriceBandPolicy(ctx, mode, 1000)
+			return err
+		})
+	}
+
+	// A 10% band around the latest appraisal of 1000
+	setPolicy(PriceBandReject)
+	_, err = env.contract.ListNFTForSale(env.ctx(testDeployer), tokenId, 1200)
+	wantCode(t, err, CodeValidation)
+	env.list(tokenId, 1100)
+
+	setPolicy(PriceBandFlag)
+	env.buy(tokenId, 1500)
+	pending, err := env.contract.GetPendingApprovalNFTs(env.ctx(inspectorAddress))
+	if err != nil || len(pending) != 1 || pending[0].Sale.PriceFlag != "" || pending[0].Sale.EarnestFlag == "" {
+		t.Errorf("pending approvals = %+v, %v", pending, err)
+	}
+}
+
+func TestReviewDeadline(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.SetReviewPeriod(ctx, 24)
+		return err
+	})
+	_, err := env.contract.SetReviewPeriod(env.ctx(testStranger), 48)
+	wantCode(t, err, CodeUnauthorized)
+
+	swept, reclaimed := env.mint(), env.mint()
+	for _, tokenId := range []string{swept, reclaimed} {
+		env.list(tokenId, 1000)
+		env.buy(tokenId, 1000)
+	}
+	if sale := env.sale(swept); sale.ReviewDeadline != sale.RequestedAt+24*60*60 {
+		t.Fatalf("sale = %+v, want a 24 hour review deadline", sale)
+	}
+
+	reclaim := func(tokenId string) (int, error) {
+		var amount int
+		err := env.submit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+			var err error
+			amount, err = env.contract.ReclaimEarnest(ctx, tokenId)
+			return err
+		})
+		return amount, err
+	}
+	_, err = reclaim(reclaimed)
+	wantCode(t, err, CodeInvalidState)
+
+	// A pending request can neither be outbid nor dropped by relisting
+	_, err = env.contract.BuyNFT(env.ctx(testBuyer), swept, 2000)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.ListNFTForSale(env.ctx(testDeployer), swept, 500)
+	wantCode(t, err, CodeInvalidState)
+
+	// A request with a later deadline is not reached by the sweep
+	env.ledger.Advance(12 * time.Hour)
+	later := env.mint()
+	env.list(later, 1000)
+	env.buy(later, 1000)
+
+	env.ledger.Advance(12 * time.Hour)
+	_, err = env.contract.ApproveSale(env.ctx(inspectorAddress), swept, "true")
+	wantCode(t, err, CodeInvalidState)
+
+	// The sweep relists both requests one batch at a time; the buyer then reclaims one of them
+	_, err = env.contract.ExpirePendingSales(env.ctx(testStranger), 0, "")
+	wantCode(t, err, CodeValidation)
+	expired, bookmark := 0, ""
+	for batches := 0; batches == 0 || bookmark != ""; batches++ {
+		if batches == 2 {
+			t.Fatalf("ExpirePendingSales did not finish in 2 batches")
+		}
+		var progress *ExpiryProgress
+		env.mustSubmit(testStranger, func(ctx kalpsdk.TransactionContextInterface) error {
+			progress, err = env.contract.ExpirePendingSales(ctx, 1, bookmark)
+			return err
+		})
+		if progress.Scanned != 1 {
+			t.Errorf("progress = %+v, want 1 sale scanned", progress)
+		}
+		expired, bookmark = expired+progress.Expired, progress.Bookmark
+	}
+	if expired != 2 {
+		t.Errorf("expired = %d, want 2", expired)
+	}
+	if sale := env.sale(later); !sale.IsPendingApproval {
+		t.Errorf("sale with a later deadline = %+v, want it pending", sale)
+	}
+	_, err = env.contract.ExpirePendingSales(env.ctx(testStranger), 1, "sale")
+	wantCode(t, err, CodeValidation)
+	if sale := env.sale(swept); !sale.IsOnSale || sale.IsPendingApproval || sale.Buyer != "" || sale.Earnest != 0 {
+		t.Errorf("sale after expiry = %+v", sale)
+	}
+
+	if amount, err := reclaim(reclaimed); err != nil || amount != 1000 {
+		t.Errorf("reclaimed = %d, %v, want 1000", amount, err)
+	}
+	_, err = reclaim(reclaimed)
+	wantCode(t, err, CodeNotFound)
+
+	// Once the buyer's new request is approved the refund of the swept one can still be reclaimed
+	env.buy(swept, 1200)
+	env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ApproveSale(ctx, swept, "true")
+		return err
+	})
+	if amount, err := reclaim(swept); err != nil || amount != 1000 {
+		t.Errorf("reclaimed = %d, %v, want 1000", amount, err)
+	}
+}
+
+func TestSaleDecisions(t *testing.T) {
+	env := newTestEnv(t)
+	tokenId := env.mint()
+	env.list(tokenId, 1000)
+	env.buy(tokenId, 1000)
+
+	decide := func(decisionJSON string) error {
+		return env.submit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.DecideSale(ctx, tokenId, decisionJSON)
+			return err
+		})
+	}
+	wantCode(t, decide(`{"decision":"reject","reasonCode":"bad_vibes"}`), CodeValidation)
+	wantCode(t, decide(`{"decision":"conditional","conditions":[]}`), CodeValidation)
+	wantCode(t, decide(`{"decision":"reject","reasonCode":"missing_documents","note":"Deed copy is unreadable"}`), "")
+
+	decisions, err := env.contract.GetSaleDecisions(env.ctx(testBuyer), tokenId)
+	if err != nil || len(decisions) != 1 || decisions[0].Buyer != testBuyer || decisions[0].Note != "Deed copy is unreadable" {
+		t.Fatalf("decisions = %+v, %v", decisions, err)
+	}
+	if sale := env.sale(tokenId); !sale.IsOnSale || sale.IsPendingApproval || sale.Buyer != "" {
+		t.Errorf("sale after rejection = %+v", sale)
+	}
+
+	env.buy(tokenId, 1000)
+	wantCode(t, decide(`{"decision":"conditional","conditions":[{"conditionId":"loan","description":"Financing letter"}]}`), "")
+	_, err = env.contract.SubmitConditionEvidence(env.ctx(testStranger), tokenId, "loan", "sha256:letter")
+	wantCode(t, err, CodeUnauthorized)
+	_, err = env.contract.SubmitConditionEvidence(env.ctx(testBuyer), tokenId, "survey", "sha256:letter")
+	wantCode(t, err, CodeNotFound)
+	env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.SubmitConditionEvidence(ctx, tokenId, "loan", "sha256:letter")
+		return err
+	})
+	if conditions := env.sale(tokenId).Conditions; conditions[0].Satisfied || conditions[0].EvidenceBy != testBuyer {
+		t.Errorf("conditions after evidence = %+v", conditions[0])
+	}
+
+	// Only the inspector decides that the evidence meets the condition
+	_, err = env.contract.SatisfyCondition(env.ctx(testBuyer), tokenId, "loan", "sha256:letter")
+	wantCode(t, err, CodeUnauthorized)
+
+	var completed bool
+	env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+		completed, err = env.contract.SatisfyCondition(ctx, tokenId, "loan", "sha256:letter")
+		return err
+	})
+	if !completed || env.nft(tokenId).Owner != testBuyer {
+		t.Errorf("completed = %v, owner = %s", completed, env.nft(tokenId).Owner)
+	}
+	if conditions := env.sale(tokenId).Conditions; len(conditions) != 1 || conditions[0].EvidenceHash != "sha256:letter" || conditions[0].SatisfiedBy != inspectorAddress {
+		t.Errorf("conditions = %+v", conditions)
+	}
+}
+
+func TestDisputes(t *testing.T) {
+	const arbitrator = "arbitrator"
+	env := newTestEnv(t)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.GrantRole(ctx, arbitratorRole, arbitrator)
+		return err
+	})
+	openDispute := func(tokenId string) {
+		env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.OpenDispute(ctx, tokenId, "Undisclosed flood damage", "sha256:photos")
+			return err
+		})
+	}
+	resolve := func(tokenId string, outcome string) error {
+		return env.submit(arbitrator, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.ResolveDispute(ctx, tokenId, outcome)
+			return err
+		})
+	}
+
+	// A completed sale is reverted and the NFT goes back to the seller
+	sold := env.mint()
+	env.list(sold, 1000)
+	env.buy(sold, 1000)
+	env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ApproveSale(ctx, sold, "true")
+		return err
+	})
+	_, err := env.contract.OpenDispute(env.ctx(testStranger), sold, "No reason", "sha256:none")
+	wantCode(t, err, CodeUnauthorized)
+	openDispute(sold)
+	_, err = env.contract.OpenDispute(env.ctx(testDeployer), sold, "Counterclaim", "sha256:deed")
+	wantCode(t, err, CodeInvalidState)
+	env.ledger.Advance(time.Hour)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.SubmitEvidence(ctx, sold, "sha256:inspection")
+		return err
+	})
+	_, err = env.contract.ListNFTForSale(env.ctx(testBuyer), sold, 2000)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.PledgeCollateral(env.ctx(testBuyer), sold, testStranger, `{"principal":500}`)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.SetUser(env.ctx(testBuyer), sold, testStranger, env.ledger.Now().Unix()+3600)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.ResolveDispute(env.ctx(testDeployer), sold, DisputeOutcomeRevert)
+	wantCode(t, err, CodeUnauthorized)
+	wantCode(t, resolve(sold, DisputeOutcomeRefund), CodeValidation)
+	wantCode(t, resolve(sold, DisputeOutcomeRevert), "")
+
+	if owner := env.nft(sold).Owner; owner != testDeployer {
+		t.Errorf("owner after revert = %s, want %s", owner, testDeployer)
+	}
+	disputes, err := env.contract.GetDisputes(env.ctx(testStranger), sold)
+	if err != nil || len(disputes) != 1 || !disputes[0].Resolved || disputes[0].Outcome != DisputeOutcomeRevert {
+		t.Fatalf("disputes = %+v, %v", disputes, err)
+	}
+	evidence, err := env.contract.GetDisputeEvidence(env.ctx(testStranger), sold, disputes[0].DisputeId)
+	if err != nil || len(evidence) != 2 || evidence[1].SubmittedBy != testDeployer {
+		t.Errorf("evidence = %+v, %v", evidence, err)
+	}
+	env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ReclaimEarnest(ctx, sold)
+		return err
+	})
+	settlements, err := env.contract.GetSettlements(env.ctx(testStranger), sold)
+	if err != nil || len(settlements) != 1 || settlements[0].ReversedByDispute != disputes[0].DisputeId || settlements[0].SalePrice != 1000 {
+		t.Errorf("settlements after revert = %+v, %v", settlements, err)
+	}
+
+	// A completed sale can only be disputed within the dispute window
+	late := env.mint()
+	env.list(late, 1000)
+	env.buy(late, 1000)
+	env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ApproveSale(ctx, late, "true")
+		return err
+	})
+	// The buyer can't relist or pledge the NFT while the seller can still dispute the sale
+	_, err = env.contract.ListNFTForSale(env.ctx(testBuyer), late, 2000)
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.PledgeCollateral(env.ctx(testBuyer), late, testStranger, `{"principal":500,"gracePeriodDays":10}`)
+	wantCode(t, err, CodeInvalidState)
+	if sale := env.sale(late); sale.IsApproved != "true" || sale.CompletedAt == 0 {
+		t.Errorf("sale after rejected relisting = %+v", sale)
+	}
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.OpenDispute(ctx, late, "Payment bounced", "sha256:statement")
+		return err
+	})
+	wantCode(t, resolve(late, DisputeOutcomeUphold), "")
+
+	env.ledger.Advance(defaultDisputeWindowDays * 24 * time.Hour)
+	_, err = env.contract.OpenDispute(env.ctx(testBuyer), late, "Leaking roof", "sha256:photos")
+	wantCode(t, err, CodeInvalidState)
+	_, err = env.contract.ListNFTForSale(env.ctx(testBuyer), late, 2000)
+	wantCode(t, err, "")
+
+	// A sale in progress is refunded and relisted, or upheld and left pending
+	refunded, upheld := env.mint(), env.mint()
+	for _, tokenId := range []string{refunded, upheld} {
+		env.list(tokenId, 1000)
+		env.buy(tokenId, 1000)
+		openDispute(tokenId)
+	}
+	_, err = env.contract.ApproveSale(env.ctx(inspectorAddress), upheld, "true")
+	wantCode(t, err, CodeInvalidState)
+	wantCode(t, resolve(refunded, DisputeOutcomeRefund), "")
+	wantCode(t, resolve(upheld, DisputeOutcomeUphold), "")
+	if sale := env.sale(refunded); !sale.IsOnSale || sale.IsPendingApproval || sale.Buyer != "" {
+		t.Errorf("refunded sale = %+v", sale)
+	}
+	if sale := env.sale(upheld); !sale.IsPendingApproval || sale.Buyer != testBuyer {
+		t.Errorf("upheld sale = %+v", sale)
+	}
+}
+
+func TestCoOwnershipConsent(t *testing.T) {
+	const spouse, partner = "spouse", "partner"
+	env := newTestEnv(t)
+	tokenId := env.mint()
+
+	coOwners := `[{"userId":"deployer","basisPoints":5000},{"userId":"spouse","basisPoints":3000},{"userId":"partner","basisPoints":2000}]`
+	_, err := env.contract.SetCoOwners(env.ctx(testDeployer), tokenId, `[{"userId":"deployer","basisPoints":5000}]`, ConsentMajority)
+	wantCode(t, err, CodeValidation)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.SetCoOwners(ctx, tokenId, coOwners, ConsentMajority)
+		return err
+	})
+	_, err = env.contract.ListNFTForSale(env.ctx(testDeployer), tokenId, 1000)
+	wantCode(t, err, CodeInvalidState)
+
+	propose := func(userID string, actionType string, params string) *ConsentAction {
+		var action *ConsentAction
+		env.mustSubmit(userID, func(ctx kalpsdk.TransactionContextInterface) error {
+			var err error
+			action, err = env.contract.ProposeAction(ctx, tokenId, actionType, params)
+			return err
+		})
+		return action
+	}
+	consent := func(userID string, actionId string) error {
+		return env.submit(userID, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.ConsentToAction(ctx, actionId)
+			return err
+		})
+	}
+
+	// The managing owner can't pledge or lease the NFT without the other co-owners
+	_, err = env.contract.PledgeCollateral(env.ctx(testDeployer), tokenId, testStranger, `{"principal":500}`)
+	wantCode(t, err, CodeInvalidState)
+	expires := env.ledger.Now().Unix() + 3600
+	_, err = env.contract.SetUser(env.ctx(testDeployer), tokenId, testStranger, expires)
+	wantCode(t, err, CodeInvalidState)
+	lease := propose(testDeployer, ActionLease, fmt.Sprintf(`{"tenant":"stranger","expires":%d}`, expires))
+	wantCode(t, consent(spouse, lease.ActionId), "")
+	if tenant, err := env.contract.UserOf(env.ctx(testStranger), tokenId); err != nil || tenant != testStranger {
+		t.Errorf("tenant = %s, %v", tenant, err)
+	}
+	if events := env.ledger.Events(); events[len(events)-1].Name != "UpdateUser" {
+		t.Errorf("lease action emitted %s, want UpdateUser", events[len(events)-1].Name)
+	}
+
+	// Action params are public, so a private listing is proposed by Merkle root only
+	_, err = env.contract.ProposeAction(env.ctx(testDeployer), tokenId, ActionList, `{"price":1000,"allowlist":{"buyers":["buyer"]}}`)
+	wantCode(t, err, CodeValidation)
+
+	// Half of the shares is not a majority, so the listing waits for a second signature
+	listing := propose(testDeployer, ActionList, `{"price":1000}`)
+	if listing.Executed {
+		t.Fatalf("listing executed with half of the shares")
+	}
+	wantCode(t, consent(testStranger, listing.ActionId), CodeUnauthorized)
+	wantCode(t, consent(testDeployer, listing.ActionId), CodeConflict)
+	wantCode(t, consent(partner, listing.ActionId), "")
+	if sale := env.sale(tokenId); !sale.IsOnSale || sale.Price != 1000 || sale.Seller != testDeployer {
+		t.Fatalf("sale = %+v", sale)
+	}
+	if events := env.ledger.Events(); events[len(events)-1].Name != "ActionExecuted" {
+		t.Errorf("listing action emitted %s, want ActionExecuted", events[len(events)-1].Name)
+	}
+
+	env.buy(tokenId, 1000)
+	_, err = env.contract.ApproveSale(env.ctx(inspectorAddress), tokenId, "true")
+	wantCode(t, err, CodeInvalidState)
+
+	// An acceptance is bound to the buy request it was proposed for
+	pending := env.sale(tokenId)
+	stale := propose(spouse, ActionAcceptSale, fmt.Sprintf(`{"termsHash":%q,"requestedAt":1}`, pending.TermsHash))
+	wantCode(t, consent(testDeployer, stale.ActionId), CodeInvalidState)
+	otherTerms := propose(spouse, ActionAcceptSale, fmt.Sprintf(`{"termsHash":"0000","requestedAt":%d}`, pending.RequestedAt))
+	wantCode(t, consent(testDeployer, otherTerms.ActionId), CodeInvalidState)
+	acceptance := propose(spouse, ActionAcceptSale, fmt.Sprintf(`{"termsHash":%q,"requestedAt":%d}`, pending.TermsHash, pending.RequestedAt))
+	wantCode(t, consent(testDeployer, acceptance.ActionId), "")
+	env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ApproveSale(ctx, tokenId, "true")
+		return err
+	})
+
+	settlements, err := env.contract.GetSettlements(env.ctx(testStranger), tokenId)
+	if err != nil || len(settlements) != 1 || len(settlements[0].CoOwnerProceeds) != 3 || settlements[0].CoOwnerProceeds[1].Amount != 285 {
+		t.Errorf("settlements = %+v, %v", settlements, err)
+	}
+	if coOwnership, err := env.contract.GetCoOwnership(env.ctx(testStranger), tokenId); err != nil || coOwnership != nil {
+		t.Errorf("co-ownership after sale = %+v, %v", coOwnership, err)
+	}
+}
+
+func TestPrivateListings(t *testing.T) {
+	env := newTestEnv(t)
+	listed, rooted := env.mint(), env.mint()
+
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ListNFTForPrivateSale(ctx, listed, 1000, `["buyer"]`, "")
+		return err
+	})
+	investors := []string{"fund-a", "fund-b", testBuyer, "fund-c", "fund-d"}
+	root := AllowlistMerkleRoot(investors)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ListNFTForPrivateSale(ctx, rooted, 1000, "", root)
+		return err
+	})
+
+	visible := func(userID string) int {
+		sales, err := env.contract.GetNFTsOnSale(env.ctx(userID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(sales)
+	}
+	if got := visible(testStranger); got != 0 {
+		t.Errorf("stranger sees %d listings, want 0", got)
+	}
+
+	// The buyers of an explicit allowlist are kept in private data, with only their root on the public record
+	saleKey, _ := env.ctx(testStranger).CreateCompositeKey(salePrefix, []string{listed})
+	if mentions(env.ledger.Get(saleKey), testBuyer) {
+		t.Errorf("public sale names an allowlisted buyer: %s", env.ledger.Get(saleKey))
+	}
+	if allowlist := env.sale(listed).Allowlist; allowlist.BuyersRoot != AllowlistMerkleRoot([]string{testBuyer}) || len(allowlist.Buyers) != 0 {
+		t.Errorf("allowlist = %+v", allowlist)
+	}
+	if got := visible(testBuyer); got != 1 {
+		t.Errorf("buyer sees %d listings before the proof, want 1", got)
+	}
+
+	_, err := env.contract.BuyNFT(env.ctx(testStranger), listed, 1000)
+	wantCode(t, err, CodeUnauthorized)
+	_, err = env.contract.BuyNFT(env.ctx(testBuyer), rooted, 1000)
+	wantCode(t, err, CodeUnauthorized)
+
+	strangerProof, _ := json.Marshal(AllowlistMerkleProof(investors, "fund-a"))
+	_, err = env.contract.ProveAllowlisted(env.ctx(testStranger), rooted, string(strangerProof))
+	wantCode(t, err, CodeUnauthorized)
+	proof, _ := json.Marshal(AllowlistMerkleProof(investors, testBuyer))
+	env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ProveAllowlisted(ctx, rooted, string(proof))
+		return err
+	})
+	if got := visible(testBuyer); got != 2 {
+		t.Errorf("buyer sees %d listings after the proof, want 2", got)
+	}
+	env.buy(listed, 1000)
+	env.buy(rooted, 1000)
+	pending, err := env.contract.GetPendingApprovalNFTs(env.ctx(inspectorAddress))
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("pending = %+v, %v", pending, err)
+	}
+	for _, sale := range pending {
+		if len(sale.Sale.Allowlist.Buyers) != 0 {
+			t.Errorf("pending sale allowlist = %+v", sale.Sale.Allowlist)
+		}
+	}
+}
+
+func TestConfidentialSale(t *testing.T) {
+	env := newTestEnv(t)
+	tokenId := env.mint()
+
+	withTerms := func(userID string, terms string, fn func(ctx kalpsdk.TransactionContextInterface) error) error {
+		tx := env.ledger.NewTransaction(userID)
+		tx.SetTransient(map[string][]byte{saleTermsTransientKey: []byte(terms)})
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return env.ledger.Commit(tx)
+	}
+
+	err := withTerms(testDeployer, `{"price":1000}`, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ListNFTForConfidentialSale(ctx, tokenId)
+		return err
+	})
+	wantCode(t, err, CodeValidation)
+	err = withTerms(testDeployer, `{"price":1000,"salt":"f3a1"}`, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ListNFTForConfidentialSale(ctx, tokenId)
+		return err
+	})
+	wantCode(t, err, "")
+
+	_, err = env.contract.BuyNFT(env.ctx(testBuyer), tokenId, 1000)
+	wantCode(t, err, CodeValidation)
+	err = withTerms(testBuyer, `{"earnest":1200}`, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.BuyNFTConfidential(ctx, tokenId)
+		return err
+	})
+	wantCode(t, err, "")
+
+	// The public record keeps the status and the hash only
+	saleKey, _ := env.ctx(testStranger).CreateCompositeKey(salePrefix, []string{tokenId})
+	var public Sale
+	if err := json.Unmarshal(env.ledger.Get(saleKey), &public); err != nil {
+		t.Fatal(err)
+	}
+	privateHash := sha256.Sum256(env.ledger.GetPrivate(saleTermsCollection, saleKey))
+	if public.Price != 0 || public.Earnest != 0 || public.Buyer != "" || !public.IsPendingApproval || public.TermsHash != hex.EncodeToString(privateHash[:]) {
+		t.Errorf("public sale = %+v", public)
+	}
+
+	_, err = env.contract.GetSaleTerms(env.ctx(testStranger), tokenId)
+	wantCode(t, err, CodeUnauthorized)
+	for _, userID := range []string{testDeployer, testBuyer, inspectorAddress} {
+		terms, err := env.contract.GetSaleTerms(env.ctx(userID), tokenId)
+		if err != nil || terms.Price != 1000 || terms.Earnest != 1200 || terms.Buyer != testBuyer {
+			t.Errorf("terms for %s = %+v, %v", userID, terms, err)
+		}
+	}
+
+	// A lien holder's sign-off names the buyer by hash only
+	var lien *Lien
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) (err error) {
+		lien, err = env.contract.RegisterLien(ctx, tokenId, "lender", 500, "sha256:mortgage")
+		return err
+	})
+	env.mustSubmit("lender", func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.SignOffLienForSale(ctx, tokenId, lien.LienId)
+		return err
+	})
+	if liens, err := env.contract.GetLiens(env.ctx(testStranger), tokenId); err != nil || liens[0].SignedOffFor != _hashUserId(testBuyer, "f3a1") {
+		t.Errorf("liens = %+v, %v", liens, err)
+	}
+
+	env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ApproveSale(ctx, tokenId, "true")
+		return err
+	})
+	if owner := env.nft(tokenId).Owner; owner != testBuyer {
+		t.Errorf("owner = %s, want %s", owner, testBuyer)
+	}
+	settlements, err := env.contract.GetSettlements(env.ctx(testStranger), tokenId)
+	if err != nil || len(settlements) != 1 || !settlements[0].Confidential || settlements[0].SalePrice != 0 {
+		t.Errorf("public settlements = %+v, %v", settlements, err)
+	}
+
+	// The refund of an expired confidential request keeps the buyer out of its public key and record
+	expired := env.mint()
+	wantCode(t, withTerms(testDeployer, `{"price":900,"salt":"0b7c"}`, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ListNFTForConfidentialSale(ctx, expired)
+		return err
+	}), "")
+	wantCode(t, withTerms(testBuyer, `{"earnest":900}`, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.BuyNFTConfidential(ctx, expired)
+		return err
+	}), "")
+	env.ledger.Advance(defaultReviewPeriodHours * time.Hour)
+	var reclaimed int
+	env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) (err error) {
+		reclaimed, err = env.contract.ReclaimEarnest(ctx, expired)
+		return err
+	})
+	if reclaimed != 900 {
+		t.Errorf("reclaimed = %d, want 900", reclaimed)
+	}
+	refunds := 0
+	for _, key := range env.ledger.Keys() {
+		objectType, attributes, err := env.ctx(testStranger).SplitCompositeKey(key)
+		if err != nil || objectType != earnestRefundPrefix {
+			continue
+		}
+		refunds++
+		var refund EarnestRefund
+		if err := json.Unmarshal(env.ledger.Get(key), &refund); err != nil {
+			t.Fatal(err)
+		}
+		if attributes[1] == testBuyer || refund.Buyer != "" || refund.Amount != 0 || !refund.Reclaimed {
+			t.Errorf("public refund %v = %+v", attributes, refund)
+		}
+	}
+	if refunds != 1 {
+		t.Errorf("found %d refunds, want 1", refunds)
+	}
+
+	// A dispute of the confidential sale names neither the buyer nor the earnest money publicly
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.GrantRole(ctx, arbitratorRole, "arbitrator")
+		return err
+	})
+	env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.OpenDispute(ctx, tokenId, "Undisclosed flood damage", "sha256:photos")
+		return err
+	})
+	env.mustSubmit(testBuyer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.SubmitEvidence(ctx, tokenId, "sha256:survey")
+		return err
+	})
+	env.mustSubmit("lender", func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ReleaseLien(ctx, tokenId, lien.LienId)
+		return err
+	})
+	env.mustSubmit("arbitrator", func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ResolveDispute(ctx, tokenId, DisputeOutcomeRevert)
+		return err
+	})
+	if owner := env.nft(tokenId).Owner; owner != testDeployer {
+		t.Errorf("owner after revert = %s, want %s", owner, testDeployer)
+	}
+	disputes, err := env.contract.GetDisputes(env.ctx(testStranger), tokenId)
+	if err != nil || len(disputes) != 1 || disputes[0].Buyer != "" || disputes[0].Earnest != 0 || disputes[0].OpenedBy != "" {
+		t.Errorf("public disputes = %+v, %v", disputes, err)
+	}
+	for _, key := range env.ledger.Keys() {
+		objectType, _, err := env.ctx(testStranger).SplitCompositeKey(key)
+		if err == nil && (objectType == disputePrefix || objectType == disputeEvidencePrefix) && mentions(env.ledger.Get(key), testBuyer) {
+			t.Errorf("public %s record names the buyer: %s", objectType, env.ledger.Get(key))
+		}
+	}
+	for _, event := range env.ledger.Events() {
+		if (event.Name == "DisputeOpened" || event.Name == "EvidenceSubmitted" || event.Name == "DisputeResolved") && mentions(event.Payload, testBuyer) {
+			t.Errorf("%s event names the buyer: %s", event.Name, event.Payload)
+		}
+	}
+
+	// Apart from owning the NFT, the buyer appears on no public record
+	for _, key := range env.ledger.Keys() {
+		objectType, attributes, err := env.ctx(testStranger).SplitCompositeKey(key)
+		if err != nil || objectType == nftPrefix || objectType == balancePrefix {
+			continue
+		}
+		for _, attribute := range attributes {
+			if attribute == testBuyer {
+				t.Errorf("public %s key names the buyer: %v", objectType, attributes)
+			}
+		}
+		if mentions(env.ledger.Get(key), testBuyer) {
+			t.Errorf("public %s record names the buyer: %s", objectType, env.ledger.Get(key))
+		}
+	}
+}
+
+func TestKYC(t *testing.T) {
+	const officer = "officer"
+	env := newTestEnv(t)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.GrantRole(ctx, complianceRole, officer)
+		return err
+	})
+	register := func(kycId string) error {
+		return env.submit(officer, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.RegisterKYC(ctx, testBuyer, kycId, "sha256:"+kycId)
+			return err
+		})
+	}
+	status := func() *KYCStatus {
+		t.Helper()
+		status, err := env.contract.GetKYCStatus(env.ctx(testStranger), testBuyer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	_, err := env.contract.RegisterKYC(env.ctx(testStranger), testBuyer, "kyc-1", "sha256:kyc-1")
+	wantCode(t, err, CodeUnauthorized)
+	wantCode(t, register(""), CodeValidation)
+	wantCode(t, register("kyc-1"), "")
+	if got := status(); !got.IsEligible || got.KycId != "kyc-1" {
+		t.Errorf("status after registration = %+v", got)
+	}
+	wantCode(t, register("kyc-2"), CodeConflict)
+
+	_, err = env.contract.RevokeKYC(env.ctx(officer), "")
+	wantCode(t, err, CodeValidation)
+	env.mustSubmit(officer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.RevokeKYC(ctx, testBuyer)
+		return err
+	})
+	if got := status(); got.IsEligible || !got.IsRevoked {
+		t.Errorf("status after revocation = %+v", got)
+	}
+
+	wantCode(t, register("kyc-2"), "")
+	if got := status(); !got.IsEligible || got.IsRevoked || got.KycId != "kyc-2" {
+		t.Errorf("status after re-registration = %+v", got)
+	}
+}
+
+func TestAutoApproval(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.SetAutoApprovalPolicy(ctx, true, 5000)
+		return err
+	})
+	env.ledger.SetKYC(testDeployer, true)
+	failedRules := func(tokenId string) []string {
+		t.Helper()
+		approvals, err := env.contract.GetAutoApprovals(env.ctx(testBuyer), tokenId)
+		if err != nil || len(approvals) == 0 {
+			t.Fatalf("auto-approvals = %+v, %v", approvals, err)
+		}
+		var failed []string
+		for _, rule := range approvals[len(approvals)-1].Rules {
+			if !rule.Passed {
+				failed = append(failed, rule.Rule)
+			}
+		}
+		return failed
+	}
+
+	// Without KYC the buyer waits for the inspector
+	pending := env.mint()
+	env.list(pending, 1000)
+	env.buy(pending, 1000)
+	if failed := failedRules(pending); len(failed) != 1 || failed[0] != RuleBuyerKYC {
+		t.Errorf("failed rules = %v", failed)
+	}
+	if sale := env.sale(pending); !sale.IsPendingApproval || env.nft(pending).Owner != testDeployer {
+		t.Errorf("sale routed to review = %+v", sale)
+	}
+
+	env.ledger.SetKYC(testBuyer, true)
+	expensive := env.mint()
+	env.list(expensive, 5000)
+	env.buy(expensive, 5000)
+	if failed := failedRules(expensive); len(failed) != 1 || failed[0] != RulePriceUnderLimit {
+		t.Errorf("failed rules = %v", failed)
+	}
+
+	approved := env.mint()
+	env.list(approved, 1000)
+	env.buy(approved, 1000)
+	if failed := failedRules(approved); len(failed) != 0 || env.nft(approved).Owner != testBuyer {
+		t.Errorf("failed rules = %v, owner = %s", failed, env.nft(approved).Owner)
+	}
+	decisions, err := env.contract.GetSaleDecisions(env.ctx(testBuyer), approved)
+	if err != nil || len(decisions) != 1 || decisions[0].DecidedBy != autoApprover {
+		t.Fatalf("decisions = %+v, %v", decisions, err)
+	}
+
+	// A rejected buyer is reviewed manually from then on
+	env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ApproveSale(ctx, pending, "false")
+		return err
+	})
+	env.ledger.Advance(time.Hour)
+	env.buy(pending, 1000)
+	if failed := failedRules(pending); len(failed) != 1 || failed[0] != RuleNoPriorRejections {
+		t.Errorf("failed rules = %v", failed)
+	}
+
+	// The rejection of a confidential sale counts too, though its public decision doesn't name the buyer
+	const investor = "investor"
+	withTerms := func(userID string, terms string, fn func(ctx kalpsdk.TransactionContextInterface) error) {
+		t.Helper()
+		tx := env.ledger.NewTransaction(userID)
+		tx.SetTransient(map[string][]byte{saleTermsTransientKey: []byte(terms)})
+		if err := fn(tx); err != nil {
+			t.Fatal(err)
+		}
+		if err := env.ledger.Commit(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	confidential := env.mint()
+	withTerms(testDeployer, `{"price":1000,"salt":"9d2e"}`, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ListNFTForConfidentialSale(ctx, confidential)
+		return err
+	})
+	withTerms(investor, `{"earnest":1000}`, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.BuyNFTConfidential(ctx, confidential)
+		return err
+	})
+	env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.ApproveSale(ctx, confidential, "false")
+		return err
+	})
+	if decisions, err := env.contract.GetSaleDecisions(env.ctx(testStranger), confidential); err != nil || len(decisions) != 1 || decisions[0].Buyer != "" {
+		t.Errorf("public decisions = %+v, %v", decisions, err)
+	}
+
+	env.ledger.SetKYC(investor, true)
+	retried := env.mint()
+	env.list(retried, 1000)
+	env.mustSubmit(investor, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.BuyNFT(ctx, retried, 1000)
+		return err
+	})
+	if failed := failedRules(retried); len(failed) != 1 || failed[0] != RuleNoPriorRejections {
+		t.Errorf("failed rules = %v", failed)
+	}
+	if owner := env.nft(retried).Owner; owner != testDeployer {
+		t.Errorf("owner = %s, want the sale routed to review", owner)
+	}
+}
+
+func TestAMLTiers(t *testing.T) {
+	const officer, director = "officer", "director"
+	env := newTestEnv(t)
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		if _, err := env.contract.GrantRole(ctx, complianceRole, officer); err != nil {
+			return err
+		}
+		if _, err := env.contract.GrantRole(ctx, directorRole, director); err != nil {
+			return err
+		}
+		_, err := env.contract.SetAMLThresholds(ctx, 5000, 10000)
+		return err
+	})
+	approve := func(tokenId string) {
+		env.mustSubmit(inspectorAddress, func(ctx kalpsdk.TransactionContextInterface) error {
+			_, err := env.contract.ApproveSale(ctx, tokenId, "true")
+			return err
+		})
+	}
+	signOff := func(userID string, tokenId string) (bool, error) {
+		var completed bool
+		err := env.submit(userID, func(ctx kalpsdk.TransactionContextInterface) (err error) {
+			completed, err = env.contract.SignOffSale(ctx, tokenId)
+			return err
+		})
+		return completed, err
+	}
+
+	// Above the compliance threshold the officer signs off after the inspector
+	mid := env.mint()
+	env.list(mid, 6000)
+	env.buy(mid, 6000)
+	approve(mid)
+	if sale := env.sale(mid); !sale.IsPendingApproval || sale.AwaitingTier != TierCompliance || env.nft(mid).Owner != testDeployer {
+		t.Fatalf("sale awaiting compliance = %+v", sale)
+	}
+	_, err := signOff(director, mid)
+	wantCode(t, err, CodeUnauthorized)
+	if completed, err := signOff(officer, mid); err != nil || !completed || env.nft(mid).Owner != testBuyer {
+		t.Fatalf("completed = %v, %v", completed, err)
+	}
+
+	// Above the director threshold every tier signs off in order
+	high := env.mint()
+	env.list(high, 12000)
+	env.buy(high, 12000)
+	approve(high)
+	env.ledger.Advance(time.Hour)
+	if completed, err := signOff(officer, high); err != nil || completed || env.sale(high).AwaitingTier != TierDirector {
+		t.Fatalf("completed = %v, %v", completed, err)
+	}
+
+	// A partly signed-off request can't be outbid, and the officer can't sign off a second tier
+	_, err = env.contract.BuyNFT(env.ctx(testBuyer), high, 15000)
+	wantCode(t, err, CodeInvalidState)
+	if sale := env.sale(high); sale.Earnest != 12000 || len(sale.SignOffs) != 2 || sale.AwaitingTier != TierDirector {
+		t.Errorf("sale after outbid attempt = %+v", sale)
+	}
+	env.mustSubmit(testDeployer, func(ctx kalpsdk.TransactionContextInterface) error {
+		_, err := env.contract.GrantRole(ctx, directorRole, officer)
+		return err
+	})
+	_, err = signOff(officer, high)
+	wantCode(t, err, CodeConflict)
+	env.ledger.Advance(time.Hour)
+	if completed, err := signOff(director, high); err != nil || !completed || env.nft(high).Owner != testBuyer {
+		t.Fatalf("completed = %v, %v", completed, err)
+	}
+
+	_, err = env.contract.ExportHighValueApprovals(env.ctx(testStranger), 0, math.MaxInt64)
+	wantCode(t, err, CodeUnauthorized)
+	report, err := env.contract.ExportHighValueApprovals(env.ctx(officer), 0, math.MaxInt64)
+	if err != nil || len(report.Approvals) != 2 || report.Checksum == "" {
+		t.Fatalf("report = %+v, %v", report, err)
+	}
+	last := report.Approvals[1]
+	if last.TokenId != high || last.Value != 12000 || len(last.Tiers) != 3 || len(last.SignOffs) != 3 ||
+		last.SignOffs[2].SignedBy != director || last.SignOffs[2].SignedAt <= last.SignOffs[0].SignedAt {
+		t.Errorf("high-value approval = %+v", last)
+	}
+}
@@ -0,0 +1,149 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for KYC revocations
+const kycRevocationPrefix = "kycRevocation"
+
+// Define objectType name for KYC registrations
+const kycRecordPrefix = "kycRecord"
+
+// KYCRevocation records that a compliance officer has revoked a user's KYC.
+// The universal kyc chaincode has no revoke call, so revocations are kept in this contract.
+type KYCRevocation struct {
+	UserId    string `json:"userId"`
+	RevokedBy string `json:"revokedBy"`
+}
+
+// KYCRecord is the latest KYC check registered for a user through this contract. The universal kyc
+// chaincode keeps the first registration only, so re-registrations after a revocation are kept here.
+type KYCRecord struct {
+	UserId       string `json:"userId"`
+	KycId        string `json:"kycId"`
+	KycHash      string `json:"kycHash"`
+	RegisteredBy string `json:"registeredBy"`
+}
+
+// KYCStatus is returned by GetKYCStatus
+type KYCStatus struct {
+	UserId     string `json:"userId"`
+	IsVerified bool   `json:"isVerified"`
+	IsRevoked  bool   `json:"isRevoked"`
+	IsEligible bool   `json:"isEligible"`      // Verified and not revoked
+	KycId      string `json:"kycId,omitempty"` // Latest check registered through RegisterKYC
+}
+
+// RegisterKYC allows a compliance officer to record a completed off-chain KYC check for a user
+func (c *TokenERC721Contract) RegisterKYC(ctx kalpsdk.TransactionContextInterface, userId string, kycId string, kycHash string) (bool, error) {
+	officerID, err := _requireRole(ctx, complianceRole)
+	if err != nil {
+		return false, err
+	}
+
+	if userId == "" || kycId == "" || kycHash == "" {
+		return false, newError(CodeValidation, "userId, kycId and kycHash must not be empty")
+	}
+
+	status, err := _getKYCStatus(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if status.IsEligible {
+		return false, newError(CodeConflict, "user %s has already passed KYC", userId).WithDetail("userId", userId)
+	}
+
+	// A previously revoked user is already known to the kyc chaincode, so only the revocation is cleared
+	if !status.IsVerified {
+		err = ctx.PutKYC(userId, kycId, kycHash)
+		if err != nil {
+			return false, wrapError(err, "failed to put KYC for user %s", userId)
+		}
+	}
+
+	revocationKey, err := _compositeKey(ctx, kycRevocationPrefix, userId)
+	if err != nil {
+		return false, err
+	}
+	err = ctx.DelStateWithoutKYC(revocationKey)
+	if err != nil {
+		return false, wrapError(err, "failed to delete KYC revocation")
+	}
+
+	recordKey, err := _compositeKey(ctx, kycRecordPrefix, userId)
+	if err != nil {
+		return false, err
+	}
+	record := KYCRecord{UserId: userId, KycId: kycId, KycHash: kycHash, RegisteredBy: officerID}
+	err = _putJSON(ctx, recordKey, record)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for KYC record")
+	}
+
+	return true, nil
+}
+
+// RevokeKYC allows a compliance officer to revoke a user's KYC for this marketplace
+func (c *TokenERC721Contract) RevokeKYC(ctx kalpsdk.TransactionContextInterface, userId string) (bool, error) {
+	officerID, err := _requireRole(ctx, complianceRole)
+	if err != nil {
+		return false, err
+	}
+
+	if userId == "" {
+		return false, newError(CodeValidation, "userId must not be empty")
+	}
+
+	revocationKey, err := _compositeKey(ctx, kycRevocationPrefix, userId)
+	if err != nil {
+		return false, err
+	}
+
+	revocation := KYCRevocation{UserId: userId, RevokedBy: officerID}
+	err = _putJSON(ctx, revocationKey, revocation)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for KYC revocation")
+	}
+
+	return true, nil
+}
+
+// GetKYCStatus returns whether a user has passed KYC and is allowed to trade on the marketplace
+func (c *TokenERC721Contract) GetKYCStatus(ctx kalpsdk.TransactionContextInterface, userId string) (*KYCStatus, error) {
+	return _getKYCStatus(ctx, userId)
+}
+
+func _getKYCStatus(ctx kalpsdk.TransactionContextInterface, userId string) (*KYCStatus, error) {
+	verified, err := ctx.GetKYC(userId)
+	if err != nil {
+		return nil, wrapError(err, "failed to get KYC for user %s", userId)
+	}
+
+	revocationKey, err := _compositeKey(ctx, kycRevocationPrefix, userId)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := _keyExists(ctx, revocationKey)
+	if err != nil {
+		return nil, wrapError(err, "failed to get KYC revocation")
+	}
+
+	recordKey, err := _compositeKey(ctx, kycRecordPrefix, userId)
+	if err != nil {
+		return nil, err
+	}
+	record := new(KYCRecord)
+	_, err = _getJSON(ctx, recordKey, record)
+	if err != nil {
+		return nil, wrapError(err, "failed to get KYC record")
+	}
+
+	return &KYCStatus{
+		UserId:     userId,
+		IsVerified: verified,
+		IsRevoked:  revoked,
+		IsEligible: verified && !revoked,
+		KycId:      record.KycId,
+	}, nil
+}
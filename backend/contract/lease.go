@@ -0,0 +1,175 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for leases
+const leasePrefix = "lease"
+
+// Lease gives a tenant the right to use an NFT's property until Expires, without owning it (ERC-4907 style)
+type Lease struct {
+	TokenId string `json:"tokenId"`
+	User    string `json:"user"`
+	Expires int64  `json:"expires"` // Unix seconds
+}
+
+// SetUser allows the owner to lease the NFT to tenant until expires (Unix seconds).
+// An empty tenant ends the current lease. The co-owners of a co-owned NFT lease it through a lease action.
+func (c *TokenERC721Contract) SetUser(ctx kalpsdk.TransactionContextInterface, tokenId string, tenant string, expires int64) (bool, error) {
+	ownerID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get owner identity")
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+	if nft.Owner != ownerID {
+		return false, newError(CodeUnauthorized, "only the owner can set the user of the NFT")
+	}
+
+	err = _requireSoleOwner(ctx, tokenId, ActionLease)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = _setUser(ctx, nft, tenant, expires)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// UserOf returns the tenant of the NFT, or an empty string if there is no lease or it has expired
+func (c *TokenERC721Contract) UserOf(ctx kalpsdk.TransactionContextInterface, tokenId string) (string, error) {
+	lease, err := _activeLease(ctx, tokenId)
+	if err != nil || lease == nil {
+		return "", err
+	}
+
+	return lease.User, nil
+}
+
+// UserExpires returns when the lease of the NFT expires in Unix seconds, or 0 if there is no lease
+func (c *TokenERC721Contract) UserExpires(ctx kalpsdk.TransactionContextInterface, tokenId string) (int64, error) {
+	lease, _, err := _getLease(ctx, tokenId)
+	if err != nil || lease == nil {
+		return 0, err
+	}
+
+	return lease.Expires, nil
+}
+
+// _activeLease returns the lease of the NFT if it has not expired at the transaction timestamp
+func _activeLease(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Lease, error) {
+	lease, found, err := _getLease(ctx, tokenId)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lease.Expires <= now {
+		return nil, nil
+	}
+
+	return lease, nil
+}
+
+func _getLease(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Lease, bool, error) {
+	exists, err := _nftExists(ctx, tokenId)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return nil, false, errNFTNotFound(tokenId)
+	}
+
+	leaseKey, err := _compositeKey(ctx, leasePrefix, tokenId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	lease := new(Lease)
+	found, err := _getJSON(ctx, leaseKey, lease)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	return lease, true, nil
+}
+
+// _endLease removes the lease of the NFT and returns it, or nil if the NFT is not leased.
+// The caller reports the ended lease in its event.
+func _endLease(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Lease, error) {
+	leaseKey, err := _compositeKey(ctx, leasePrefix, tokenId)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := new(Lease)
+	found, err := _getJSON(ctx, leaseKey, lease)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	err = ctx.DelStateWithoutKYC(leaseKey)
+	if err != nil {
+		return nil, wrapError(err, "failed to delete lease")
+	}
+
+	return lease, nil
+}
+
+// _setUser leases the NFT to tenant until expires, or ends the current lease if tenant is empty.
+// It reports whether it emitted an UpdateUser event, which it doesn't when there was no lease to end.
+func _setUser(ctx kalpsdk.TransactionContextInterface, nft *Nft, tenant string, expires int64) (bool, error) {
+	tokenId := nft.TokenId
+	err := _requireNotPaused(ctx, ScopeTransfer)
+	if err != nil {
+		return false, err
+	}
+
+	err = _requireNoOpenDispute(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	err = _requireNotFrozen(ctx, tokenId, nft.Owner, tenant)
+	if err != nil {
+		return false, err
+	}
+
+	if tenant == "" {
+		ended, err := _endLease(ctx, tokenId)
+		if err != nil || ended == nil {
+			return false, err
+		}
+		return true, _setJSONEvent(ctx, "UpdateUser", Lease{TokenId: tokenId})
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	if expires <= now {
+		return false, newError(CodeValidation, "lease expiry must be in the future").WithDetail("expires", expires)
+	}
+
+	lease := &Lease{TokenId: tokenId, User: tenant, Expires: expires}
+	leaseKey, err := _compositeKey(ctx, leasePrefix, tokenId)
+	if err != nil {
+		return false, err
+	}
+	err = _putJSON(ctx, leaseKey, lease)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for lease")
+	}
+
+	return true, _setJSONEvent(ctx, "UpdateUser", lease)
+}
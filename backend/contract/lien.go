@@ -0,0 +1,234 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for liens
+const lienPrefix = "lien"
+
+// Lien is an encumbrance registered against an NFT, such as a mortgage, tax or mechanic's lien.
+// While it is unreleased the NFT can only be transferred to a buyer the holder has signed off on.
+type Lien struct {
+	LienId       string `json:"lienId"` // Id of the transaction that registered the lien
+	TokenId      string `json:"tokenId"`
+	Holder       string `json:"holder"`
+	Amount       int    `json:"amount"`
+	DocumentHash string `json:"documentHash"`
+	RegisteredBy string `json:"registeredBy"`
+	RegisteredAt int64  `json:"registeredAt"`           // Unix seconds
+	SignedOffFor string `json:"signedOffFor,omitempty"` // Buyer the holder has allowed the NFT to be sold to, hashed for a confidential sale
+	Released     bool   `json:"released"`
+	ReleasedBy   string `json:"releasedBy,omitempty"`
+	ReleasedAt   int64  `json:"releasedAt,omitempty"`
+}
+
+// RegisterLien allows the admin or a compliance officer to record a lien against an NFT
+func (c *TokenERC721Contract) RegisterLien(ctx kalpsdk.TransactionContextInterface, tokenId string, holder string, amount int, documentHash string) (*Lien, error) {
+	clientID, err := c._requireAdminOrRole(ctx, complianceRole, "register a lien")
+	if err != nil {
+		return nil, err
+	}
+
+	if holder == "" || documentHash == "" {
+		return nil, newError(CodeValidation, "lien holder and document hash must not be empty")
+	}
+	if amount <= 0 {
+		return nil, newError(CodeValidation, "lien amount must be positive")
+	}
+
+	exists, err := _nftExists(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNFTNotFound(tokenId)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lien := &Lien{
+		LienId:       ctx.GetTxID(),
+		TokenId:      tokenId,
+		Holder:       holder,
+		Amount:       amount,
+		DocumentHash: documentHash,
+		RegisteredBy: clientID,
+		RegisteredAt: now,
+	}
+	err = _putLien(ctx, lien)
+	if err != nil {
+		return nil, err
+	}
+
+	err = _setJSONEvent(ctx, "LienRegistered", lien)
+	if err != nil {
+		return nil, err
+	}
+
+	return lien, nil
+}
+
+// ReleaseLien allows the lien holder or the admin to release a lien
+func (c *TokenERC721Contract) ReleaseLien(ctx kalpsdk.TransactionContextInterface, tokenId string, lienId string) (bool, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get client identity")
+	}
+
+	lien, err := _readLien(ctx, tokenId, lienId)
+	if err != nil {
+		return false, err
+	}
+
+	config, err := c._readConfig(ctx)
+	if err != nil {
+		return false, err
+	}
+	if clientID != lien.Holder && clientID != config.Admin {
+		return false, newError(CodeUnauthorized, "only the lien holder or the admin can release a lien")
+	}
+	if lien.Released {
+		return false, newError(CodeInvalidState, "lien is already released").WithDetail("lienId", lienId)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	lien.Released = true
+	lien.ReleasedBy = clientID
+	lien.ReleasedAt = now
+	lien.SignedOffFor = ""
+	err = _putLien(ctx, lien)
+	if err != nil {
+		return false, err
+	}
+
+	err = _setJSONEvent(ctx, "LienReleased", lien)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SignOffLienForSale allows the lien holder to let the pending sale of the NFT complete while the lien is unreleased
+func (c *TokenERC721Contract) SignOffLienForSale(ctx kalpsdk.TransactionContextInterface, tokenId string, lienId string) (bool, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get client identity")
+	}
+
+	lien, err := _readLien(ctx, tokenId, lienId)
+	if err != nil {
+		return false, err
+	}
+	if clientID != lien.Holder {
+		return false, newError(CodeUnauthorized, "only the lien holder can sign off on a sale")
+	}
+	if lien.Released {
+		return false, newError(CodeInvalidState, "lien is already released").WithDetail("lienId", lienId)
+	}
+
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+	if !sale.IsPendingApproval || sale.Buyer == "" {
+		return false, newError(CodeInvalidState, "NFT has no pending sale").WithDetail("tokenId", tokenId)
+	}
+
+	// The buyer of a confidential sale must not appear on the public lien
+	lien.SignedOffFor = sale.Buyer
+	if sale.Confidential {
+		lien.SignedOffFor = _hashUserId(sale.Buyer, sale.termsSalt)
+	}
+	err = _putLien(ctx, lien)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetLiens returns every lien registered against an NFT, released or not
+func (c *TokenERC721Contract) GetLiens(ctx kalpsdk.TransactionContextInterface, tokenId string) ([]*Lien, error) {
+	return _listJSON[Lien](ctx, lienPrefix, tokenId)
+}
+
+// _clearLiensForTransfer fails if an unreleased lien has not been signed off for a transfer to the new owner.
+// A sign-off covers a single sale, so it is cleared once used.
+func _clearLiensForTransfer(ctx kalpsdk.TransactionContextInterface, tokenId string, to string) error {
+	liens, err := _listJSON[Lien](ctx, lienPrefix, tokenId)
+	if err != nil {
+		return err
+	}
+
+	var sale *Sale
+	for _, lien := range liens {
+		if lien.Released {
+			continue
+		}
+
+		// A sign-off for a confidential sale names the buyer by a hash keyed with the salt of its terms
+		signedOff := lien.SignedOffFor != "" && lien.SignedOffFor == to
+		if !signedOff && lien.SignedOffFor != "" {
+			if sale == nil {
+				sale, _, err = _getSale(ctx, tokenId)
+				if err != nil {
+					return err
+				}
+			}
+			signedOff = sale != nil && sale.Confidential && lien.SignedOffFor == _hashUserId(to, sale.termsSalt)
+		}
+		if !signedOff {
+			return newError(CodeInvalidState, "NFT %s has an unreleased lien held by %s", tokenId, lien.Holder).
+				WithDetail("tokenId", tokenId).WithDetail("lienId", lien.LienId)
+		}
+
+		lien.SignedOffFor = ""
+		err = _putLien(ctx, lien)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func _readLien(ctx kalpsdk.TransactionContextInterface, tokenId string, lienId string) (*Lien, error) {
+	lienKey, err := _compositeKey(ctx, lienPrefix, tokenId, lienId)
+	if err != nil {
+		return nil, err
+	}
+
+	lien := new(Lien)
+	found, err := _getJSON(ctx, lienKey, lien)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, newError(CodeNotFound, "lien not found").WithDetail("tokenId", tokenId).WithDetail("lienId", lienId)
+	}
+
+	return lien, nil
+}
+
+func _putLien(ctx kalpsdk.TransactionContextInterface, lien *Lien) error {
+	lienKey, err := _compositeKey(ctx, lienPrefix, lien.TokenId, lien.LienId)
+	if err != nil {
+		return err
+	}
+
+	err = _putJSON(ctx, lienKey, lien)
+	if err != nil {
+		return wrapError(err, "failed to put state for lien")
+	}
+
+	return nil
+}
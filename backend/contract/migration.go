@@ -0,0 +1,245 @@
+package contract
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define key name for the schema version every record has been upgraded to
+const schemaVersionKey = "schemaVersion"
+
+// Records written before versioning was introduced have no version field
+const initialSchemaVersion = 1
+
+// Upper bound of records scanned by a single RunMigrations transaction
+const maxMigrationBatchSize = 500
+
+// migration upgrades the records of one objectType to Version.
+// Upgrade edits the decoded JSON record in place; the version field is set by the caller.
+type migration struct {
+	Version     int
+	ObjectType  string
+	Description string
+	Upgrade     func(record map[string]interface{}) error
+}
+
+// migrations lists every schema change in version order. Append new steps here
+// and readers will upgrade older records in memory until RunMigrations rewrites them.
+var migrations = []migration{
+	{
+		Version:     2,
+		ObjectType:  nftPrefix,
+		Description: "NFTs minted before royalties were introduced get an explicit zero royalty",
+		Upgrade: func(record map[string]interface{}) error {
+			if _, ok := record["royalty"]; !ok {
+				record["royalty"] = map[string]interface{}{"receiver": "", "basisPoints": 0}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		ObjectType:  salePrefix,
+		Description: "Sales are stamped with a schema version",
+		Upgrade:     func(record map[string]interface{}) error { return nil },
+	},
+	{
+		Version:     3,
+		ObjectType:  salePrefix,
+		Description: "Listings made before leases were introduced let the lease survive the sale",
+		Upgrade: func(record map[string]interface{}) error {
+			if _, ok := record["leaseSurvivesSale"]; !ok {
+				record["leaseSurvivesSale"] = true
+			}
+			return nil
+		},
+	},
+}
+
+// MigrationProgress reports the outcome of one RunMigrations batch.
+// Bookmark is empty once every record has been upgraded.
+type MigrationProgress struct {
+	SchemaVersion int    `json:"schemaVersion"` // Version stored on the ledger after this batch
+	TargetVersion int    `json:"targetVersion"`
+	Scanned       int    `json:"scanned"`
+	Upgraded      int    `json:"upgraded"`
+	Bookmark      string `json:"bookmark"`
+}
+
+// RunMigrations allows the admin to upgrade up to batchSize records to the latest schema.
+// Call it again with the returned bookmark until the bookmark is empty.
+func (c *TokenERC721Contract) RunMigrations(ctx kalpsdk.TransactionContextInterface, batchSize int, bookmark string) (*MigrationProgress, error) {
+	_, err := c._requireAdmin(ctx, "run migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	if batchSize <= 0 || batchSize > maxMigrationBatchSize {
+		return nil, newError(CodeValidation, "batch size must be between 1 and %d", maxMigrationBatchSize)
+	}
+
+	schemaVersion, err := _readSchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &MigrationProgress{SchemaVersion: schemaVersion, TargetVersion: latestSchemaVersion()}
+
+	// Object types are visited in key order, so a single bookmark spans all of them
+	for _, objectType := range _migratedObjectTypes() {
+		iterator, err := ctx.GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return nil, wrapError(err, "failed to get state by partial composite key for %s", objectType)
+		}
+
+		for iterator.HasNext() {
+			queryResponse, err := iterator.Next()
+			if err != nil {
+				iterator.Close()
+				return nil, wrapError(err, "failed to get next %s", objectType)
+			}
+			if bookmark != "" && queryResponse.Key <= bookmark {
+				continue
+			}
+			if progress.Scanned == batchSize {
+				progress.Bookmark = bookmark
+				iterator.Close()
+				return progress, nil
+			}
+
+			progress.Scanned++
+			bookmark = queryResponse.Key
+
+			if _recordVersion(queryResponse.Value) >= progress.TargetVersion {
+				continue
+			}
+			valueBytes, err := _upgradeRecord(objectType, queryResponse.Value)
+			if err != nil {
+				iterator.Close()
+				return nil, err
+			}
+			err = ctx.PutStateWithoutKYC(queryResponse.Key, valueBytes)
+			if err != nil {
+				iterator.Close()
+				return nil, wrapError(err, "failed to put state for key %s", queryResponse.Key)
+			}
+			progress.Upgraded++
+		}
+		iterator.Close()
+	}
+
+	// Every record has been scanned, so the ledger is at the latest schema
+	if schemaVersion < progress.TargetVersion {
+		err = ctx.PutStateWithoutKYC(schemaVersionKey, []byte(strconv.Itoa(progress.TargetVersion)))
+		if err != nil {
+			return nil, wrapError(err, "failed to put state for schema version")
+		}
+		progress.SchemaVersion = progress.TargetVersion
+	}
+
+	return progress, nil
+}
+
+// GetSchemaVersion returns the schema version every record has been upgraded to
+func (c *TokenERC721Contract) GetSchemaVersion(ctx kalpsdk.TransactionContextInterface) (int, error) {
+	return _readSchemaVersion(ctx)
+}
+
+func _readSchemaVersion(ctx kalpsdk.TransactionContextInterface) (int, error) {
+	versionBytes, err := ctx.GetState(schemaVersionKey)
+	if err != nil {
+		return 0, wrapError(err, "failed to get schema version")
+	}
+	if len(versionBytes) == 0 {
+		return initialSchemaVersion, nil
+	}
+
+	version, err := strconv.Atoi(string(versionBytes))
+	if err != nil {
+		return 0, wrapError(err, "invalid schema version %q", versionBytes)
+	}
+
+	return version, nil
+}
+
+// latestSchemaVersion is the version new records are written with
+func latestSchemaVersion() int {
+	version := initialSchemaVersion
+	for _, m := range migrations {
+		if m.Version > version {
+			version = m.Version
+		}
+	}
+	return version
+}
+
+// _upgradeRecord applies the pending migrations of objectType to a stored record and stamps it
+// with the latest schema version. Records that are already at the latest version are returned unchanged.
+func _upgradeRecord(objectType string, valueBytes []byte) ([]byte, error) {
+	version := _recordVersion(valueBytes)
+	latest := latestSchemaVersion()
+	if version >= latest || !_hasMigrations(objectType) {
+		return valueBytes, nil
+	}
+
+	var record map[string]interface{}
+	err := json.Unmarshal(valueBytes, &record)
+	if err != nil {
+		return nil, wrapError(err, "failed to unmarshal %s record for migration", objectType)
+	}
+
+	for _, m := range migrations {
+		if m.ObjectType != objectType || m.Version <= version {
+			continue
+		}
+		err = m.Upgrade(record)
+		if err != nil {
+			return nil, wrapError(err, "failed to migrate %s record to version %d", objectType, m.Version)
+		}
+	}
+	record["version"] = latest
+
+	upgradedBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, wrapError(err, "failed to marshal migrated %s record", objectType)
+	}
+
+	return upgradedBytes, nil
+}
+
+func _hasMigrations(objectType string) bool {
+	for _, m := range migrations {
+		if m.ObjectType == objectType {
+			return true
+		}
+	}
+	return false
+}
+
+// _recordVersion returns the version field of a stored record
+func _recordVersion(valueBytes []byte) int {
+	var record struct {
+		Version int `json:"version"`
+	}
+	if json.Unmarshal(valueBytes, &record) != nil || record.Version == 0 {
+		return initialSchemaVersion
+	}
+	return record.Version
+}
+
+// _migratedObjectTypes returns the object types that have migrations, in key order
+func _migratedObjectTypes() []string {
+	var objectTypes []string
+	seen := make(map[string]bool)
+	for _, m := range migrations {
+		if !seen[m.ObjectType] {
+			seen[m.ObjectType] = true
+			objectTypes = append(objectTypes, m.ObjectType)
+		}
+	}
+	sort.Strings(objectTypes)
+	return objectTypes
+}
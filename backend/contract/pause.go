@@ -0,0 +1,177 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for pause records
+const pausePrefix = "pause"
+
+// Define role name of the users who can pause the marketplace
+const pauserRole = "pauser"
+
+// Scopes that can be paused independently. ScopeAll stops every one of them.
+const (
+	ScopeAll      = "all"
+	ScopeMint     = "mint"
+	ScopeList     = "list"
+	ScopeBuy      = "buy"
+	ScopeApprove  = "approve"
+	ScopeTransfer = "transfer"
+)
+
+var pauseScopes = []string{ScopeAll, ScopeMint, ScopeList, ScopeBuy, ScopeApprove, ScopeTransfer}
+
+// PauseRecord is stored while a scope is paused
+type PauseRecord struct {
+	Scope    string `json:"scope"`
+	PausedBy string `json:"pausedBy"`
+	PausedAt int64  `json:"pausedAt"` // Unix seconds
+}
+
+// ScopeStatus is returned by GetPauseStatus for every scope
+type ScopeStatus struct {
+	Scope    string `json:"scope"`
+	Paused   bool   `json:"paused"`
+	PausedBy string `json:"pausedBy,omitempty"`
+	PausedAt int64  `json:"pausedAt,omitempty"`
+}
+
+// PauseEvent is the payload of the Paused and Unpaused events
+type PauseEvent struct {
+	Scope string `json:"scope"`
+	By    string `json:"by"`
+}
+
+// Pause allows a pauser to stop all activity in scope until it is unpaused
+func (c *TokenERC721Contract) Pause(ctx kalpsdk.TransactionContextInterface, scope string) (bool, error) {
+	clientID, err := _requireRole(ctx, pauserRole)
+	if err != nil {
+		return false, err
+	}
+
+	pauseKey, err := _pauseKey(ctx, scope)
+	if err != nil {
+		return false, err
+	}
+
+	paused, err := _keyExists(ctx, pauseKey)
+	if err != nil {
+		return false, err
+	}
+	if paused {
+		return false, newError(CodeConflict, "scope %s is already paused", scope).WithDetail("scope", scope)
+	}
+
+	now, err := _txUnixTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	err = _putJSON(ctx, pauseKey, PauseRecord{Scope: scope, PausedBy: clientID, PausedAt: now})
+	if err != nil {
+		return false, wrapError(err, "failed to put state for pause record")
+	}
+
+	err = _setJSONEvent(ctx, "Paused", PauseEvent{Scope: scope, By: clientID})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Unpause allows a pauser to resume activity in a paused scope
+func (c *TokenERC721Contract) Unpause(ctx kalpsdk.TransactionContextInterface, scope string) (bool, error) {
+	clientID, err := _requireRole(ctx, pauserRole)
+	if err != nil {
+		return false, err
+	}
+
+	pauseKey, err := _pauseKey(ctx, scope)
+	if err != nil {
+		return false, err
+	}
+
+	paused, err := _keyExists(ctx, pauseKey)
+	if err != nil {
+		return false, err
+	}
+	if !paused {
+		return false, newError(CodeInvalidState, "scope %s is not paused", scope).WithDetail("scope", scope)
+	}
+
+	err = ctx.DelStateWithoutKYC(pauseKey)
+	if err != nil {
+		return false, wrapError(err, "failed to delete pause record")
+	}
+
+	err = _setJSONEvent(ctx, "Unpaused", PauseEvent{Scope: scope, By: clientID})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetPauseStatus returns whether each scope is paused
+func (c *TokenERC721Contract) GetPauseStatus(ctx kalpsdk.TransactionContextInterface) ([]*ScopeStatus, error) {
+	statuses := make([]*ScopeStatus, 0, len(pauseScopes))
+	for _, scope := range pauseScopes {
+		record, paused, err := _getPauseRecord(ctx, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		status := &ScopeStatus{Scope: scope, Paused: paused}
+		if paused {
+			status.PausedBy = record.PausedBy
+			status.PausedAt = record.PausedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// _requireNotPaused fails if scope, or the whole marketplace, is paused
+func _requireNotPaused(ctx kalpsdk.TransactionContextInterface, scope string) error {
+	for _, s := range []string{ScopeAll, scope} {
+		_, paused, err := _getPauseRecord(ctx, s)
+		if err != nil {
+			return err
+		}
+		if paused {
+			return newError(CodeInvalidState, "%s is paused", scope).WithDetail("scope", s)
+		}
+	}
+
+	return nil
+}
+
+func _getPauseRecord(ctx kalpsdk.TransactionContextInterface, scope string) (*PauseRecord, bool, error) {
+	pauseKey, err := _pauseKey(ctx, scope)
+	if err != nil {
+		return nil, false, err
+	}
+
+	record := new(PauseRecord)
+	found, err := _getJSON(ctx, pauseKey, record)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	return record, true, nil
+}
+
+func _pauseKey(ctx kalpsdk.TransactionContextInterface, scope string) (string, error) {
+	valid := false
+	for _, s := range pauseScopes {
+		valid = valid || s == scope
+	}
+	if !valid {
+		return "", newError(CodeValidation, "unknown pause scope %s", scope).WithDetail("scope", scope)
+	}
+
+	return _compositeKey(ctx, pausePrefix, scope)
+}
@@ -0,0 +1,185 @@
+package contract
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define key name for the accepted payment engine
+const paymentEngineKey = "paymentEngine"
+
+// Define key name for the identity the payment engine submits purchases with
+const paymentEngineUserKey = "paymentEngineUser"
+
+// Define docType of payment records, as written by kalpsdk for payable contracts
+const paymentInfoDocType = "PAYMENT-INFO"
+
+// SetPaymentEngine allows the admin to configure the application reference id of the payment engine
+// whose payments are accepted by BuyNFTWithPayment, and the identity the engine submits them with
+func (c *TokenERC721Contract) SetPaymentEngine(ctx kalpsdk.TransactionContextInterface, applicationReferenceId string, engineUserId string) (bool, error) {
+	_, err := c._requireAdmin(ctx, "set the payment engine")
+	if err != nil {
+		return false, err
+	}
+
+	if applicationReferenceId == "" || engineUserId == "" {
+		return false, newError(CodeValidation, "applicationReferenceId and engineUserId must not be empty")
+	}
+
+	err = ctx.PutStateWithoutKYC(paymentEngineKey, []byte(applicationReferenceId))
+	if err != nil {
+		return false, wrapError(err, "failed to put state for payment engine")
+	}
+	err = ctx.PutStateWithoutKYC(paymentEngineUserKey, []byte(engineUserId))
+	if err != nil {
+		return false, wrapError(err, "failed to put state for payment engine user")
+	}
+
+	return true, nil
+}
+
+// BuyNFTWithPayment allows the payment engine to request a sale approval for a buyer who paid through
+// the payment gateway. The PaymentTracker payload is passed as the last argument, the same as for
+// payable contracts, and each gateway payment can fund one purchase only.
+func (c *TokenERC721Contract) BuyNFTWithPayment(ctx kalpsdk.TransactionContextInterface, tokenId string, buyer string, paymentTracker string) (bool, error) {
+	return _withStateCache(ctx, func(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+		return c.buyNFTWithPayment(ctx, tokenId, buyer, paymentTracker)
+	})
+}
+
+func (c *TokenERC721Contract) buyNFTWithPayment(ctx kalpsdk.TransactionContextInterface, tokenId string, buyerID string, paymentTracker string) (bool, error) {
+	// The payment tracker is only trusted when the payment engine itself submits it
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return false, wrapError(err, "failed to get client identity")
+	}
+	engineUserBytes, err := ctx.GetState(paymentEngineUserKey)
+	if err != nil {
+		return false, wrapError(err, "failed to get payment engine user")
+	}
+	if len(engineUserBytes) == 0 {
+		return false, newError(CodeInvalidState, "payment engine is not configured, call SetPaymentEngine() first")
+	}
+	if clientID != string(engineUserBytes) {
+		return false, newError(CodeUnauthorized, "only the payment engine can submit fiat-settled purchases")
+	}
+
+	if buyerID == "" {
+		return false, newError(CodeValidation, "buyer must not be empty")
+	}
+	status, err := _getKYCStatus(ctx, buyerID)
+	if err != nil {
+		return false, err
+	}
+	if !status.IsEligible {
+		return false, newError(CodeUnauthorized, "buyer %s has not passed KYC", buyerID).WithDetail("buyer", buyerID)
+	}
+
+	var payment kalpsdk.PaymentTracker
+	err = json.Unmarshal([]byte(paymentTracker), &payment)
+	if err != nil {
+		return false, wrapErrorAs(CodeValidation, err, "failed to unmarshal payment tracker")
+	}
+
+	if !c.CheckPaymentDetails(payment) {
+		return false, newError(CodeValidation, "payment transaction does not have valid amount or currency code")
+	}
+
+	// Only payments confirmed by the configured payment engine are accepted
+	engineBytes, err := ctx.GetState(paymentEngineKey)
+	if err != nil {
+		return false, wrapError(err, "failed to get payment engine")
+	}
+	if len(engineBytes) == 0 {
+		return false, newError(CodeInvalidState, "payment engine is not configured, call SetPaymentEngine() first")
+	}
+	if payment.PaymentMetaData.ApplicationReferenceId != string(engineBytes) {
+		return false, newError(CodeValidation, "payment was not processed by the configured payment engine")
+	}
+	if payment.PaymentTransactionID == "" {
+		return false, newError(CodeValidation, "payment transaction id must not be empty")
+	}
+
+	paymentKey, err := _paymentInfoKey(ctx, payment.PaymentTransactionID)
+	if err != nil {
+		return false, err
+	}
+	used, err := _keyExists(ctx, paymentKey)
+	if err != nil {
+		return false, wrapError(err, "failed to get payment info")
+	}
+	if used {
+		return false, newError(CodeConflict, "payment %s has already been used", payment.PaymentTransactionID).
+			WithDetail("paymentTransactionId", payment.PaymentTransactionID)
+	}
+
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	// The earnest is the paid amount, which must be whole and cover the asking price
+	amount := payment.PaymentMetaData.Amount
+	if amount != math.Trunc(amount) || amount >= math.MaxInt64 {
+		return false, newError(CodeValidation, "paid amount must be a whole number").WithDetail("amount", amount)
+	}
+	if amount < float64(sale.Price) {
+		return false, newError(CodeValidation, "paid amount must be equal to or greater than the asking price").WithDetail("price", sale.Price)
+	}
+
+	// Store the PAYMENT-INFO record the same way kalpsdk does for payable contracts
+	payment.DocType = paymentInfoDocType
+	payment.TransactionId = ctx.GetTxID()
+	payment.AssetId = tokenId
+	payment.AssetDocType = salePrefix
+
+	paymentBytes, err := json.Marshal(payment)
+	if err != nil {
+		return false, wrapError(err, "failed to marshal payment info")
+	}
+
+	// Keyed by the gateway's payment id, so a payment cannot be used twice
+	err = ctx.PutStateWithoutKYC(paymentKey, paymentBytes)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for payment info")
+	}
+
+	return _placeBuyRequest(ctx, tokenId, buyerID, int(amount), payment.PaymentTransactionID, false)
+}
+
+// GetPaymentInfo returns the payment confirmation linked to the pending sale of the token
+func (c *TokenERC721Contract) GetPaymentInfo(ctx kalpsdk.TransactionContextInterface, tokenId string) (*kalpsdk.PaymentTracker, error) {
+	sale, err := _readSale(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if sale.PaymentTxId == "" {
+		return nil, newError(CodeNotFound, "no payment is linked to the sale of tokenId %s", tokenId).WithDetail("tokenId", tokenId)
+	}
+
+	return _readPaymentInfo(ctx, sale.PaymentTxId)
+}
+
+func _readPaymentInfo(ctx kalpsdk.TransactionContextInterface, paymentTxId string) (*kalpsdk.PaymentTracker, error) {
+	paymentKey, err := _paymentInfoKey(ctx, paymentTxId)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := new(kalpsdk.PaymentTracker)
+	found, err := _getJSON(ctx, paymentKey, payment)
+	if err != nil {
+		return nil, wrapError(err, "failed to get payment info %s", paymentTxId)
+	}
+	if !found {
+		return nil, newError(CodeNotFound, "payment info %s not found", paymentTxId).WithDetail("paymentTxId", paymentTxId)
+	}
+
+	return payment, nil
+}
+
+func _paymentInfoKey(ctx kalpsdk.TransactionContextInterface, paymentTransactionId string) (string, error) {
+	return _compositeKey(ctx, paymentInfoDocType, paymentTransactionId)
+}
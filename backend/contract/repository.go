@@ -0,0 +1,281 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// The repository helpers are the only place contract state is read and written.
+// Reads return (value, found, error) so a missing record is never confused with a ledger failure.
+
+// _getJSON reads the JSON value stored under key into v
+func _getJSON(ctx kalpsdk.TransactionContextInterface, key string, v interface{}) (bool, error) {
+	valueBytes, err := ctx.GetState(key)
+	if err != nil {
+		return false, wrapError(err, "failed to get state for key %s", key)
+	}
+	if len(valueBytes) == 0 {
+		return false, nil
+	}
+
+	err = json.Unmarshal(valueBytes, v)
+	if err != nil {
+		return false, wrapError(err, "failed to unmarshal state for key %s", key)
+	}
+
+	return true, nil
+}
+
+// _putJSON stores v as JSON under key
+func _putJSON(ctx kalpsdk.TransactionContextInterface, key string, v interface{}) error {
+	valueBytes, err := json.Marshal(v)
+	if err != nil {
+		return wrapError(err, "failed to marshal state for key %s", key)
+	}
+
+	err = ctx.PutStateWithoutKYC(key, valueBytes)
+	if err != nil {
+		return wrapError(err, "failed to put state for key %s", key)
+	}
+
+	return nil
+}
+
+// _getRecord reads a versioned record of objectType into v, upgrading it in memory if it was
+// written with an older schema
+func _getRecord(ctx kalpsdk.TransactionContextInterface, objectType string, key string, v interface{}) (bool, error) {
+	valueBytes, err := ctx.GetState(key)
+	if err != nil {
+		return false, wrapError(err, "failed to get state for key %s", key)
+	}
+	if len(valueBytes) == 0 {
+		return false, nil
+	}
+
+	valueBytes, err = _upgradeRecord(objectType, valueBytes)
+	if err != nil {
+		return false, err
+	}
+
+	err = json.Unmarshal(valueBytes, v)
+	if err != nil {
+		return false, wrapError(err, "failed to unmarshal state for key %s", key)
+	}
+
+	return true, nil
+}
+
+// _keyExists returns whether any value is stored under key
+func _keyExists(ctx kalpsdk.TransactionContextInterface, key string) (bool, error) {
+	valueBytes, err := ctx.GetState(key)
+	if err != nil {
+		return false, wrapError(err, "failed to get state for key %s", key)
+	}
+
+	return len(valueBytes) > 0, nil
+}
+
+// _compositeKey creates a composite key and wraps the error
+func _compositeKey(ctx kalpsdk.TransactionContextInterface, objectType string, attributes ...string) (string, error) {
+	key, err := ctx.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", wrapError(err, "failed to create %s composite key", objectType)
+	}
+
+	return key, nil
+}
+
+// _listJSON decodes every value stored under the partial composite key
+func _listJSON[T any](ctx kalpsdk.TransactionContextInterface, objectType string, attributes ...string) ([]*T, error) {
+	iterator, err := ctx.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, wrapError(err, "failed to get state by partial composite key for %s", objectType)
+	}
+	defer iterator.Close()
+
+	var values []*T
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, wrapError(err, "failed to get next %s", objectType)
+		}
+
+		valueBytes, err := _upgradeRecord(objectType, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		value := new(T)
+		err = json.Unmarshal(valueBytes, value)
+		if err != nil {
+			return nil, wrapError(err, "failed to unmarshal %s data", objectType)
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func errNFTNotFound(tokenId string) *ContractError {
+	return newError(CodeNotFound, "NFT not found for tokenId %s", tokenId).WithDetail("tokenId", tokenId)
+}
+
+func errSaleNotFound(tokenId string) *ContractError {
+	return newError(CodeNotFound, "NFT not listed for sale").WithDetail("tokenId", tokenId)
+}
+
+// _getNFT returns the NFT, or found false if it has not been minted
+func _getNFT(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Nft, bool, error) {
+	nftKey, err := _compositeKey(ctx, nftPrefix, tokenId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	nft := new(Nft)
+	found, err := _getRecord(ctx, nftPrefix, nftKey, nft)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	return nft, true, nil
+}
+
+// _readNFT returns the NFT or a NOT_FOUND error
+func _readNFT(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Nft, error) {
+	nft, found, err := _getNFT(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errNFTNotFound(tokenId)
+	}
+
+	return nft, nil
+}
+
+func _nftExists(ctx kalpsdk.TransactionContextInterface, tokenId string) (bool, error) {
+	nftKey, err := _compositeKey(ctx, nftPrefix, tokenId)
+	if err != nil {
+		return false, err
+	}
+
+	return _keyExists(ctx, nftKey)
+}
+
+func _putNFT(ctx kalpsdk.TransactionContextInterface, nft *Nft) error {
+	nftKey, err := _compositeKey(ctx, nftPrefix, nft.TokenId)
+	if err != nil {
+		return err
+	}
+
+	nft.Version = latestSchemaVersion()
+
+	// The pledge is stored under its own key
+	stored := *nft
+	stored.Pledge = nil
+	return _putJSON(ctx, nftKey, &stored)
+}
+
+// _getSale returns the sale listing, or found false if the NFT has never been listed
+func _getSale(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Sale, bool, error) {
+	saleKey, err := _compositeKey(ctx, salePrefix, tokenId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sale := new(Sale)
+	found, err := _getRecord(ctx, salePrefix, saleKey, sale)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	if sale.Confidential {
+		err = _loadSaleTerms(ctx, saleKey, sale)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return sale, true, nil
+}
+
+// _readSale returns the sale listing or a NOT_FOUND error
+func _readSale(ctx kalpsdk.TransactionContextInterface, tokenId string) (*Sale, error) {
+	sale, found, err := _getSale(ctx, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errSaleNotFound(tokenId)
+	}
+
+	return sale, nil
+}
+
+func _putSale(ctx kalpsdk.TransactionContextInterface, sale *Sale) error {
+	saleKey, err := _compositeKey(ctx, salePrefix, sale.TokenId)
+	if err != nil {
+		return err
+	}
+
+	sale.Version = latestSchemaVersion()
+	err = _putAllowlistBuyers(ctx, sale)
+	if err != nil {
+		return err
+	}
+	err = _indexReviewDeadline(ctx, sale)
+	if err != nil {
+		return err
+	}
+	if !sale.Confidential {
+		sale.TermsHash, err = sale.termsHash()
+		if err != nil {
+			return err
+		}
+		return _putJSON(ctx, saleKey, sale)
+	}
+
+	public, err := _putSaleTerms(ctx, saleKey, sale)
+	if err != nil {
+		return err
+	}
+	sale.TermsHash = public.TermsHash
+	return _putJSON(ctx, saleKey, public)
+}
+
+// _listSales returns every sale listing, whatever its state. The terms of confidential sales
+// are not filled in; read those with _readSale.
+func _listSales(ctx kalpsdk.TransactionContextInterface) ([]*Sale, error) {
+	return _listJSON[Sale](ctx, salePrefix)
+}
+
+// _withMetadata combines the sale with the metadata of the listed NFT
+func _withMetadata(ctx kalpsdk.TransactionContextInterface, sale *Sale) (*SaleWithMetadata, error) {
+	nft, err := _readNFT(ctx, sale.TokenId)
+	if err != nil {
+		return nil, wrapError(err, "failed to get NFT metadata for tokenId %s", sale.TokenId)
+	}
+
+	return &SaleWithMetadata{
+		Sale:        *sale,
+		NftMetadata: *nft,
+	}, nil
+}
+
+// _txUnixTime returns the transaction timestamp in Unix seconds, which is the same on every endorsing peer
+func _txUnixTime(ctx kalpsdk.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetTxTimestamp()
+	if err != nil {
+		return 0, wrapError(err, "failed to get transaction timestamp")
+	}
+
+	return timestamp.GetSeconds(), nil
+}
+
+// _sortableTime formats Unix seconds so that composite keys containing it sort chronologically
+func _sortableTime(unixTime int64) string {
+	return fmt.Sprintf("%020d", unixTime)
+}
@@ -0,0 +1,103 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for role assignments
+const rolePrefix = "role"
+
+// Define role names
+const complianceRole = "compliance"
+
+// RoleGrant is the record stored for every role assignment
+type RoleGrant struct {
+	Role      string `json:"role"`
+	UserId    string `json:"userId"`
+	GrantedBy string `json:"grantedBy"`
+}
+
+// GrantRole allows the admin to assign a role to a user
+func (c *TokenERC721Contract) GrantRole(ctx kalpsdk.TransactionContextInterface, role string, userId string) (bool, error) {
+	clientID, err := c._requireAdmin(ctx, "grant roles")
+	if err != nil {
+		return false, err
+	}
+
+	if role == "" || userId == "" {
+		return false, newError(CodeValidation, "role and userId must not be empty")
+	}
+
+	roleKey, err := _compositeKey(ctx, rolePrefix, role, userId)
+	if err != nil {
+		return false, err
+	}
+
+	grant := RoleGrant{Role: role, UserId: userId, GrantedBy: clientID}
+	err = _putJSON(ctx, roleKey, grant)
+	if err != nil {
+		return false, wrapError(err, "failed to put state for role grant")
+	}
+
+	return true, nil
+}
+
+// RevokeRole allows the admin to remove a role from a user
+func (c *TokenERC721Contract) RevokeRole(ctx kalpsdk.TransactionContextInterface, role string, userId string) (bool, error) {
+	_, err := c._requireAdmin(ctx, "revoke roles")
+	if err != nil {
+		return false, err
+	}
+
+	hasRole, err := _hasRole(ctx, role, userId)
+	if err != nil {
+		return false, err
+	}
+	if !hasRole {
+		return false, newError(CodeNotFound, "user %s does not have role %s", userId, role)
+	}
+
+	roleKey, err := _compositeKey(ctx, rolePrefix, role, userId)
+	if err != nil {
+		return false, err
+	}
+
+	err = ctx.DelStateWithoutKYC(roleKey)
+	if err != nil {
+		return false, wrapError(err, "failed to delete role grant")
+	}
+
+	return true, nil
+}
+
+// HasRole returns whether the user has been granted the role
+func (c *TokenERC721Contract) HasRole(ctx kalpsdk.TransactionContextInterface, role string, userId string) (bool, error) {
+	return _hasRole(ctx, role, userId)
+}
+
+func _hasRole(ctx kalpsdk.TransactionContextInterface, role string, userId string) (bool, error) {
+	roleKey, err := _compositeKey(ctx, rolePrefix, role, userId)
+	if err != nil {
+		return false, err
+	}
+
+	return _keyExists(ctx, roleKey)
+}
+
+// _requireRole returns the caller's identity if the caller has been granted the role
+func _requireRole(ctx kalpsdk.TransactionContextInterface, role string) (string, error) {
+	clientID, err := ctx.GetUserID()
+	if err != nil {
+		return "", wrapError(err, "failed to get client identity")
+	}
+
+	hasRole, err := _hasRole(ctx, role, clientID)
+	if err != nil {
+		return "", err
+	}
+	if !hasRole {
+		return "", newError(CodeUnauthorized, "only a user with the %s role can perform this action", role)
+	}
+
+	return clientID, nil
+}
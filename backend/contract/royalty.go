@@ -0,0 +1,238 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define objectType name for settlement records
+const settlementPrefix = "settlement"
+
+// Define key name for the platform fee config
+const platformFeeKey = "platformFee"
+
+// Fees and royalties are expressed in basis points of the sale price
+const maxBasisPoints = 10000
+
+// Royalty is the per-token royalty set at mint (EIP-2981 style)
+type Royalty struct {
+	Receiver    string `json:"receiver"`
+	BasisPoints int    `json:"basisPoints"`
+}
+
+// PlatformFee is the marketplace fee charged on every settled sale
+type PlatformFee struct {
+	Receiver    string `json:"receiver"`
+	BasisPoints int    `json:"basisPoints"`
+}
+
+// RoyaltyAmount is returned by RoyaltyInfo
+type RoyaltyAmount struct {
+	Receiver      string `json:"receiver"`
+	RoyaltyAmount int    `json:"royaltyAmount"`
+}
+
+// Settlement records how the proceeds of an approved sale are split.
+// The contract holds no payment escrow, so the split is recorded for off-chain payout.
+type Settlement struct {
+	TokenId             string             `json:"tokenId"`
+	TxId                string             `json:"txId"`
+	Seller              string             `json:"seller"`
+	Buyer               string             `json:"buyer"`
+	SalePrice           int                `json:"salePrice"`
+	SellerProceeds      int                `json:"sellerProceeds"`
+	RoyaltyReceiver     string             `json:"royaltyReceiver"`
+	RoyaltyAmount       int                `json:"royaltyAmount"`
+	PlatformFeeReceiver string             `json:"platformFeeReceiver"`
+	PlatformFeeAmount   int                `json:"platformFeeAmount"`
+	CoOwnerProceeds     []*CoOwnerProceeds `json:"coOwnerProceeds,omitempty"` // Split of the seller proceeds of a co-owned NFT
+	Confidential        bool               `json:"confidential,omitempty"`    // The buyer and amounts are only kept in the private copy of the record
+	ReversedAt          int64              `json:"reversedAt,omitempty"`      // Unix seconds a dispute reverted the sale, the split is then no longer owed
+	ReversedByDispute   string             `json:"reversedByDispute,omitempty"`
+}
+
+// CoOwnerProceeds is the part of the seller proceeds owed to one co-owner
+type CoOwnerProceeds struct {
+	UserId string `json:"userId"`
+	Amount int    `json:"amount"`
+}
+
+// SetPlatformFee allows the admin to configure the platform fee charged on every settled sale
+func (c *TokenERC721Contract) SetPlatformFee(ctx kalpsdk.TransactionContextInterface, receiver string, basisPoints int) (bool, error) {
+	_, err := c._requireAdmin(ctx, "set the platform fee")
+	if err != nil {
+		return false, err
+	}
+
+	if basisPoints < 0 || basisPoints > maxBasisPoints {
+		return false, newError(CodeValidation, "platform fee must be between 0 and %d basis points", maxBasisPoints)
+	}
+	if basisPoints > 0 && receiver == "" {
+		return false, newError(CodeValidation, "platform fee receiver must not be empty")
+	}
+
+	err = _putJSON(ctx, platformFeeKey, PlatformFee{Receiver: receiver, BasisPoints: basisPoints})
+	if err != nil {
+		return false, wrapError(err, "failed to put state for platform fee")
+	}
+
+	return true, nil
+}
+
+// GetPlatformFee returns the configured platform fee
+func (c *TokenERC721Contract) GetPlatformFee(ctx kalpsdk.TransactionContextInterface) (*PlatformFee, error) {
+	return _readPlatformFee(ctx)
+}
+
+// RoyaltyInfo returns the royalty receiver and the royalty owed for a sale of the token at salePrice
+func (c *TokenERC721Contract) RoyaltyInfo(ctx kalpsdk.TransactionContextInterface, tokenId string, salePrice int) (*RoyaltyAmount, error) {
+	if salePrice < 0 {
+		return nil, newError(CodeValidation, "sale price must not be negative")
+	}
+
+	nft, err := _readNFT(ctx, tokenId)
+	if err != nil {
+		return nil, wrapError(err, "failed to read NFT")
+	}
+
+	return &RoyaltyAmount{
+		Receiver:      nft.Royalty.Receiver,
+		RoyaltyAmount: _basisPointsOf(salePrice, nft.Royalty.BasisPoints),
+	}, nil
+}
+
+// GetSettlements returns the settlement records of every approved sale of the token
+func (c *TokenERC721Contract) GetSettlements(ctx kalpsdk.TransactionContextInterface, tokenId string) ([]*Settlement, error) {
+	return _listJSON[Settlement](ctx, settlementPrefix, tokenId)
+}
+
+// _recordSettlement computes the seller proceeds, royalty and platform fee of an approved sale and stores the split
+func _recordSettlement(ctx kalpsdk.TransactionContextInterface, sale *Sale, nft *Nft) (*Settlement, error) {
+	fee, err := _readPlatformFee(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The buyer's earnest is the amount tendered for the property
+	salePrice := sale.Earnest
+	royaltyAmount := _basisPointsOf(salePrice, nft.Royalty.BasisPoints)
+	feeAmount := _basisPointsOf(salePrice, fee.BasisPoints)
+	if royaltyAmount+feeAmount > salePrice {
+		return nil, newError(CodeInvalidState, "royalty and platform fee exceed the sale price")
+	}
+
+	settlement := &Settlement{
+		TokenId:             sale.TokenId,
+		TxId:                ctx.GetTxID(),
+		Seller:              sale.Seller,
+		Buyer:               sale.Buyer,
+		SalePrice:           salePrice,
+		SellerProceeds:      salePrice - royaltyAmount - feeAmount,
+		RoyaltyReceiver:     nft.Royalty.Receiver,
+		RoyaltyAmount:       royaltyAmount,
+		PlatformFeeReceiver: fee.Receiver,
+		PlatformFeeAmount:   feeAmount,
+	}
+
+	// The seller proceeds of a co-owned NFT are split by share, with the rounding remainder going to the first co-owner
+	coOwnership, err := _getCoOwnership(ctx, sale.TokenId)
+	if err != nil {
+		return nil, err
+	}
+	if coOwnership != nil {
+		remainder := settlement.SellerProceeds
+		for _, coOwner := range coOwnership.CoOwners {
+			amount := _basisPointsOf(settlement.SellerProceeds, coOwner.BasisPoints)
+			settlement.CoOwnerProceeds = append(settlement.CoOwnerProceeds, &CoOwnerProceeds{UserId: coOwner.UserId, Amount: amount})
+			remainder -= amount
+		}
+		settlement.CoOwnerProceeds[0].Amount += remainder
+	}
+
+	settlementKey, err := _compositeKey(ctx, settlementPrefix, sale.TokenId, settlement.TxId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The buyer and amounts of a confidential sale go to the private data collection only
+	public := settlement
+	if sale.Confidential {
+		settlement.Confidential = true
+		err = _putPrivateCopy(ctx, settlementKey, settlement)
+		if err != nil {
+			return nil, err
+		}
+		public = &Settlement{
+			TokenId:             settlement.TokenId,
+			TxId:                settlement.TxId,
+			Seller:              settlement.Seller,
+			RoyaltyReceiver:     settlement.RoyaltyReceiver,
+			PlatformFeeReceiver: settlement.PlatformFeeReceiver,
+			Confidential:        true,
+		}
+	}
+
+	err = _putJSON(ctx, settlementKey, public)
+	if err != nil {
+		return nil, wrapError(err, "failed to put state for settlement")
+	}
+
+	return settlement, nil
+}
+
+func _readPlatformFee(ctx kalpsdk.TransactionContextInterface) (*PlatformFee, error) {
+	// No fee is charged until the admin configures one
+	fee := new(PlatformFee)
+	_, err := _getJSON(ctx, platformFeeKey, fee)
+	if err != nil {
+		return nil, wrapError(err, "failed to get platform fee")
+	}
+
+	return fee, nil
+}
+
+func _basisPointsOf(amount int, basisPoints int) int {
+	return amount * basisPoints / maxBasisPoints
+}
+
+// _reverseSettlement marks the settlement of a sale reverted by a dispute, keeping the original split for the audit trail
+func _reverseSettlement(ctx kalpsdk.TransactionContextInterface, tokenId string, settlementTxId string, disputeId string, reversedAt int64) error {
+	settlementKey, err := _compositeKey(ctx, settlementPrefix, tokenId, settlementTxId)
+	if err != nil {
+		return err
+	}
+
+	settlement := new(Settlement)
+	found, err := _getJSON(ctx, settlementKey, settlement)
+	if err != nil {
+		return wrapError(err, "failed to get settlement")
+	}
+	if !found {
+		return newError(CodeInternal, "settlement of the reverted sale is missing").WithDetail("txId", settlementTxId)
+	}
+
+	if settlement.Confidential {
+		private := new(Settlement)
+		found, err = _getPrivateCopy(ctx, settlementKey, private)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return newError(CodeInternal, "settlement is missing from the private data collection").WithDetail("txId", settlementTxId)
+		}
+		private.ReversedAt = reversedAt
+		private.ReversedByDispute = disputeId
+		err = _putPrivateCopy(ctx, settlementKey, private)
+		if err != nil {
+			return err
+		}
+	}
+
+	settlement.ReversedAt = reversedAt
+	settlement.ReversedByDispute = disputeId
+	err = _putJSON(ctx, settlementKey, settlement)
+	if err != nil {
+		return wrapError(err, "failed to put state for settlement")
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package contract
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// Define key name for the flag set once the marketplace starts trading
+const marketplaceOpenKey = "marketplaceOpen"
+
+// Define the pseudo prefix of the config keys in a snapshot
+const configPrefix = "config"
+
+// Define the pseudo prefix of private data in a snapshot, followed by the objectType, e.g. "private/sale"
+const privatePrefix = "private/"
+
+// Number of entries returned per ExportState page
+const exportPageSize = 100
+
+// Config keys carried over between deployments. Name, symbol, the contract config and the schema
+// version are excluded, since every deployment sets its own through Initialize and RunMigrations.
+var configKeys = []string{
+	tokenCounterKey, platformFeeKey, paymentEngineKey, paymentEngineUserKey, reviewPeriodKey,
+	priceBandPolicyKey, autoApprovalPolicyKey, amlThresholdsKey, disputeWindowKey,
+}
+
+// Composite key prefixes carried over between deployments. Every objectType the contract writes
+// belongs here, otherwise a migration silently drops it.
+var snapshotPrefixes = []string{
+	nftPrefix, salePrefix, balancePrefix, rolePrefix, kycRevocationPrefix, kycRecordPrefix, paymentInfoDocType,
+	settlementPrefix, pausePrefix, freezePrefix, freezeHistoryPrefix, lienPrefix, collateralPrefix, leasePrefix,
+	appraisalPrefix, earnestRefundPrefix, saleDecisionPrefix, disputePrefix, disputeEvidencePrefix,
+	coOwnershipPrefix, consentActionPrefix, allowlistProofPrefix, autoApprovalPrefix, highValueApprovalPrefix,
+}
+
+// Composite key prefixes with private copies in the saleTermsCollection
+var privateSnapshotPrefixes = []string{salePrefix, earnestRefundPrefix, settlementPrefix, highValueApprovalPrefix, disputePrefix, disputeEvidencePrefix, saleAllowlistPrefix, buyerRejectionPrefix}
+
+// SnapshotPrefixes returns every prefix ExportState accepts, in the order they should be imported.
+// Config comes first so the token counter is in place before any NFT.
+func SnapshotPrefixes() []string {
+	prefixes := append([]string{configPrefix}, snapshotPrefixes...)
+	for _, prefix := range privateSnapshotPrefixes {
+		prefixes = append(prefixes, privatePrefix+prefix)
+	}
+	return prefixes
+}
+
+// StateEntry is one ledger key and its raw value
+type StateEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// StatePage is a page of exported ledger state. Bookmark is empty on the last page.
+type StatePage struct {
+	Prefix   string       `json:"prefix"`
+	Entries  []StateEntry `json:"entries"`
+	Bookmark string       `json:"bookmark"`
+	Checksum string       `json:"checksum"` // SHA-256 of the prefix and entries
+}
+
+// ExportState allows the admin to export the state under one of the SnapshotPrefixes one page at a time,
+// for import into a new deployment. Pass the bookmark of the previous page to continue.
+func (c *TokenERC721Contract) ExportState(ctx kalpsdk.TransactionContextInterface, prefix string, bookmark string) (*StatePage, error) {
+	_, err := c._requireAdmin(ctx, "export state")
+	if err != nil {
+		return nil, err
+	}
+
+	page := &StatePage{Prefix: prefix, Entries: []StateEntry{}}
+	if prefix == configPrefix {
+		for _, key := range configKeys {
+			valueBytes, err := ctx.GetState(key)
+			if err != nil {
+				return nil, wrapError(err, "failed to get state for key %s", key)
+			}
+			if len(valueBytes) > 0 {
+				page.Entries = append(page.Entries, StateEntry{Key: key, Value: valueBytes})
+			}
+		}
+		page.Checksum = page.checksum()
+		return page, nil
+	}
+
+	iterator, err := _snapshotIterator(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, wrapError(err, "failed to get next %s", prefix)
+		}
+
+		// Keys are returned in order, so the page resumes after the bookmark
+		if bookmark != "" && queryResponse.Key <= bookmark {
+			continue
+		}
+		if len(page.Entries) == exportPageSize {
+			page.Bookmark = page.Entries[len(page.Entries)-1].Key
+			break
+		}
+
+		page.Entries = append(page.Entries, StateEntry{Key: queryResponse.Key, Value: queryResponse.Value})
+	}
+
+	page.Checksum = page.checksum()
+	return page, nil
+}
+
+// ImportState allows the admin to import a page produced by ExportState on another deployment.
+// The whole page is rejected if its checksum does not match or any of its keys already exists.
+// Import is only allowed before the marketplace is opened.
+func (c *TokenERC721Contract) ImportState(ctx kalpsdk.TransactionContextInterface, batchJSON string) (int, error) {
+	_, err := c._requireAdmin(ctx, "import state")
+	if err != nil {
+		return 0, err
+	}
+
+	open, err := _keyExists(ctx, marketplaceOpenKey)
+	if err != nil {
+		return 0, err
+	}
+	if open {
+		return 0, newError(CodeInvalidState, "state cannot be imported after the marketplace has opened")
+	}
+
+	page := new(StatePage)
+	err = json.Unmarshal([]byte(batchJSON), page)
+	if err != nil {
+		return 0, wrapErrorAs(CodeValidation, err, "failed to unmarshal state batch")
+	}
+	if page.checksum() != page.Checksum {
+		return 0, newError(CodeValidation, "state batch checksum does not match").WithDetail("prefix", page.Prefix)
+	}
+
+	for _, entry := range page.Entries {
+		err = _validateSnapshotKey(ctx, page.Prefix, entry.Key)
+		if err != nil {
+			return 0, err
+		}
+
+		exists, err := _snapshotKeyExists(ctx, page.Prefix, entry.Key)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, newError(CodeConflict, "key already exists").WithDetail("key", entry.Key)
+		}
+	}
+
+	for _, entry := range page.Entries {
+		err = _putSnapshotEntry(ctx, page.Prefix, entry)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(page.Entries), nil
+}
+
+// OpenMarketplace allows the admin to open the marketplace, which ends the import window.
+// Minting or listing an NFT opens the marketplace as well.
+func (c *TokenERC721Contract) OpenMarketplace(ctx kalpsdk.TransactionContextInterface) (bool, error) {
+	_, err := c._requireAdmin(ctx, "open the marketplace")
+	if err != nil {
+		return false, err
+	}
+
+	err = _openMarketplace(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// _openMarketplace sets the marketplace open flag if it is not set yet
+func _openMarketplace(ctx kalpsdk.TransactionContextInterface) error {
+	open, err := _keyExists(ctx, marketplaceOpenKey)
+	if err != nil || open {
+		return err
+	}
+
+	err = ctx.PutStateWithoutKYC(marketplaceOpenKey, []byte("true"))
+	if err != nil {
+		return wrapError(err, "failed to put state for marketplace open flag")
+	}
+
+	return nil
+}
+
+func _isSnapshotPrefix(prefix string) bool {
+	if objectType, ok := strings.CutPrefix(prefix, privatePrefix); ok {
+		return _contains(privateSnapshotPrefixes, objectType)
+	}
+	return _contains(snapshotPrefixes, prefix)
+}
+
+func _contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// _snapshotIterator iterates over the public or private state under a snapshot prefix
+func _snapshotIterator(ctx kalpsdk.TransactionContextInterface, prefix string) (shim.StateQueryIteratorInterface, error) {
+	if !_isSnapshotPrefix(prefix) {
+		return nil, newError(CodeValidation, "unknown export prefix %s", prefix).WithDetail("prefix", prefix)
+	}
+
+	objectType, private := strings.CutPrefix(prefix, privatePrefix)
+	if !private {
+		iterator, err := ctx.GetStateByPartialCompositeKey(prefix, []string{})
+		if err != nil {
+			return nil, wrapError(err, "failed to get state by partial composite key for %s", prefix)
+		}
+		return iterator, nil
+	}
+
+	stub, err := _stub(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := stub.GetPrivateDataByPartialCompositeKey(saleTermsCollection, objectType, []string{})
+	if err != nil {
+		return nil, wrapError(err, "failed to get private data by partial composite key for %s", objectType)
+	}
+	return iterator, nil
+}
+
+func _snapshotKeyExists(ctx kalpsdk.TransactionContextInterface, prefix string, key string) (bool, error) {
+	if !strings.HasPrefix(prefix, privatePrefix) {
+		return _keyExists(ctx, key)
+	}
+
+	stub, err := _stub(ctx)
+	if err != nil {
+		return false, err
+	}
+	valueBytes, err := stub.GetPrivateData(saleTermsCollection, key)
+	if err != nil {
+		return false, wrapError(err, "failed to get private data for key %s", key)
+	}
+	return len(valueBytes) > 0, nil
+}
+
+func _putSnapshotEntry(ctx kalpsdk.TransactionContextInterface, prefix string, entry StateEntry) error {
+	if !strings.HasPrefix(prefix, privatePrefix) {
+		err := ctx.PutStateWithoutKYC(entry.Key, entry.Value)
+		if err != nil {
+			return wrapError(err, "failed to put state for key %s", entry.Key)
+		}
+		if prefix == salePrefix {
+			return _indexImportedSale(ctx, entry.Value)
+		}
+		return nil
+	}
+
+	stub, err := _stub(ctx)
+	if err != nil {
+		return err
+	}
+	err = stub.PutPrivateData(saleTermsCollection, entry.Key, entry.Value)
+	if err != nil {
+		return wrapError(err, "failed to put private data for key %s", entry.Key)
+	}
+	return nil
+}
+
+// _indexImportedSale rebuilds the review deadline index entry of an imported sale, which is not exported
+func _indexImportedSale(ctx kalpsdk.TransactionContextInterface, value []byte) error {
+	valueBytes, err := _upgradeRecord(salePrefix, value)
+	if err != nil {
+		return err
+	}
+	sale := new(Sale)
+	err = json.Unmarshal(valueBytes, sale)
+	if err != nil {
+		return wrapError(err, "failed to unmarshal %s data", salePrefix)
+	}
+
+	return _indexReviewDeadline(ctx, sale)
+}
+
+// _validateSnapshotKey checks that key belongs to the prefix of the page it was imported with
+func _validateSnapshotKey(ctx kalpsdk.TransactionContextInterface, prefix string, key string) error {
+	if prefix == configPrefix {
+		for _, configKey := range configKeys {
+			if key == configKey {
+				return nil
+			}
+		}
+		return newError(CodeValidation, "key is not an importable config key").WithDetail("key", key)
+	}
+
+	objectType, _, err := ctx.SplitCompositeKey(key)
+	if err != nil || objectType != strings.TrimPrefix(prefix, privatePrefix) || !_isSnapshotPrefix(prefix) {
+		return newError(CodeValidation, "key does not belong to prefix %s", prefix).WithDetail("key", key)
+	}
+
+	return nil
+}
+
+// checksum hashes the prefix and entries, each prefixed by its length
+func (p *StatePage) checksum() string {
+	hash := sha256.New()
+	write := func(b []byte) {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(b)))
+		hash.Write(length[:])
+		hash.Write(b)
+	}
+
+	write([]byte(p.Prefix))
+	for _, entry := range p.Entries {
+		write([]byte(entry.Key))
+		write(entry.Value)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
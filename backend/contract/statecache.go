@@ -0,0 +1,223 @@
+package contract
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// stateCache is a per-transaction overlay on the transaction context. GetState only sees
+// committed state, so the cache buffers puts and deletes, serves reads from the buffer
+// first and remembers committed values it has already fetched. Writes reach the ledger
+// when Flush is called, in sorted key order so every endorser produces the same write set.
+type stateCache struct {
+	kalpsdk.TransactionContextInterface
+	reads  map[string][]byte
+	writes map[string]*cachedWrite
+}
+
+type cachedWrite struct {
+	value   []byte
+	deleted bool
+	withKYC bool
+}
+
+func newStateCache(ctx kalpsdk.TransactionContextInterface) *stateCache {
+	return &stateCache{
+		TransactionContextInterface: ctx,
+		reads:                       make(map[string][]byte),
+		writes:                      make(map[string]*cachedWrite),
+	}
+}
+
+// _withStateCache runs fn against a state cache and flushes the buffered writes if fn succeeds
+func _withStateCache[T any](ctx kalpsdk.TransactionContextInterface, fn func(ctx kalpsdk.TransactionContextInterface) (T, error)) (T, error) {
+	// Nested calls share the outer cache, which is flushed by the outermost call
+	if _, ok := ctx.(*stateCache); ok {
+		return fn(ctx)
+	}
+
+	cache := newStateCache(ctx)
+	result, err := fn(cache)
+	if err != nil {
+		return result, err
+	}
+
+	err = cache.Flush()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return result, nil
+}
+
+func (s *stateCache) GetState(key string) ([]byte, error) {
+	if write, ok := s.writes[key]; ok {
+		if write.deleted {
+			return nil, nil
+		}
+		return copyBytes(write.value), nil
+	}
+
+	if value, ok := s.reads[key]; ok {
+		return copyBytes(value), nil
+	}
+
+	value, err := s.TransactionContextInterface.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	s.reads[key] = copyBytes(value)
+
+	return value, nil
+}
+
+func (s *stateCache) PutStateWithoutKYC(key string, value []byte) error {
+	s.writes[key] = &cachedWrite{value: copyBytes(value)}
+	return nil
+}
+
+func (s *stateCache) PutStateWithKYC(key string, value []byte) error {
+	s.writes[key] = &cachedWrite{value: copyBytes(value), withKYC: true}
+	return nil
+}
+
+func (s *stateCache) DelStateWithoutKYC(key string) error {
+	s.writes[key] = &cachedWrite{deleted: true}
+	return nil
+}
+
+func (s *stateCache) DelStateWithKYC(key string) error {
+	s.writes[key] = &cachedWrite{deleted: true, withKYC: true}
+	return nil
+}
+
+// GetStateByPartialCompositeKey merges the buffered writes under the composite key prefix into the committed results
+func (s *stateCache) GetStateByPartialCompositeKey(objectType string, keys []string) (kalpsdk.StateQueryIteratorInterface, error) {
+	iterator, err := s.TransactionContextInterface.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		iterator.Close()
+		return nil, err
+	}
+
+	return s.merge(iterator, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// GetStateByRange merges the buffered writes in [startKey, endKey) into the committed results
+func (s *stateCache) GetStateByRange(startKey string, endKey string) (kalpsdk.StateQueryIteratorInterface, error) {
+	iterator, err := s.TransactionContextInterface.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.merge(iterator, func(key string) bool {
+		return key >= startKey && (endKey == "" || key < endKey)
+	})
+}
+
+func (s *stateCache) merge(iterator kalpsdk.StateQueryIteratorInterface, inRange func(key string) bool) (kalpsdk.StateQueryIteratorInterface, error) {
+	defer iterator.Close()
+
+	merged := make(map[string][]byte)
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		merged[queryResponse.Key] = queryResponse.Value
+	}
+
+	for key, write := range s.writes {
+		if !inRange(key) {
+			continue
+		}
+		if write.deleted {
+			delete(merged, key)
+		} else {
+			merged[key] = copyBytes(write.value)
+		}
+	}
+
+	results := make([]*queryresult.KV, 0, len(merged))
+	for key, value := range merged {
+		results = append(results, &queryresult.KV{Key: key, Value: value})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Key < results[j].Key
+	})
+
+	return &sliceIterator{results: results}, nil
+}
+
+// Flush writes the buffered puts and deletes to the transaction context in sorted key order
+func (s *stateCache) Flush() error {
+	keys := make([]string, 0, len(s.writes))
+	for key := range s.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ctx := s.TransactionContextInterface
+	for _, key := range keys {
+		write := s.writes[key]
+
+		var err error
+		switch {
+		case write.deleted && write.withKYC:
+			err = ctx.DelStateWithKYC(key)
+		case write.deleted:
+			err = ctx.DelStateWithoutKYC(key)
+		case write.withKYC:
+			err = ctx.PutStateWithKYC(key, write.value)
+		default:
+			err = ctx.PutStateWithoutKYC(key, write.value)
+		}
+		if err != nil {
+			return wrapError(err, "failed to flush state for key %s", key)
+		}
+		s.reads[key] = write.value
+	}
+
+	s.writes = make(map[string]*cachedWrite)
+	return nil
+}
+
+// sliceIterator iterates over query results held in memory
+type sliceIterator struct {
+	results []*queryresult.KV
+	next    int
+}
+
+func (it *sliceIterator) HasNext() bool {
+	return it.next < len(it.results)
+}
+
+func (it *sliceIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, newError(CodeInternal, "iterator has no more results")
+	}
+	result := it.results[it.next]
+	it.next++
+	return result, nil
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
+
+func copyBytes(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append([]byte(nil), value...)
+}
@@ -0,0 +1,77 @@
+package contract
+
+import (
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// _transferNFT moves the NFT to a new owner, updating both balances and emitting the Transfer event.
+// Every path that changes the owner of an existing NFT goes through here, so the checks that can
+// block a transfer apply to all of them.
+func _transferNFT(ctx kalpsdk.TransactionContextInterface, nft *Nft, to string) error {
+	return _transferNFTEndingLease(ctx, nft, to, nil)
+}
+
+// _transferNFTEndingLease is _transferNFT for a sale that ended the lease of the NFT, reported in the Transfer event
+func _transferNFTEndingLease(ctx kalpsdk.TransactionContextInterface, nft *Nft, to string, endedLease *Lease) error {
+	err := _requireNotPaused(ctx, ScopeTransfer)
+	if err != nil {
+		return err
+	}
+
+	err = _requireNotFrozen(ctx, nft.TokenId, nft.Owner, to)
+	if err != nil {
+		return err
+	}
+
+	err = _requireNotPledged(ctx, nft.TokenId)
+	if err != nil {
+		return err
+	}
+
+	err = _requireNoOpenDispute(ctx, nft.TokenId)
+	if err != nil {
+		return err
+	}
+
+	err = _clearLiensForTransfer(ctx, nft.TokenId, to)
+	if err != nil {
+		return err
+	}
+
+	// The new owner holds the NFT alone
+	err = _endCoOwnership(ctx, nft.TokenId)
+	if err != nil {
+		return err
+	}
+
+	from := nft.Owner
+	nft.Owner = to
+
+	// Update the NFT state
+	err = _putNFT(ctx, nft)
+	if err != nil {
+		return wrapError(err, "failed to put state for updated NFT")
+	}
+
+	// Remove the NFT from the previous owner's balance
+	balanceKeyFrom, err := _compositeKey(ctx, balancePrefix, from, nft.TokenId)
+	if err != nil {
+		return err
+	}
+	err = ctx.DelStateWithoutKYC(balanceKeyFrom)
+	if err != nil {
+		return wrapError(err, "failed to delete previous owner's balance key")
+	}
+
+	// Add the NFT to the new owner's balance
+	balanceKeyTo, err := _compositeKey(ctx, balancePrefix, to, nft.TokenId)
+	if err != nil {
+		return err
+	}
+	err = ctx.PutStateWithoutKYC(balanceKeyTo, []byte{'\u0000'})
+	if err != nil {
+		return wrapError(err, "failed to put state for new owner's balance key")
+	}
+
+	return _setJSONEvent(ctx, "Transfer", Transfer{From: from, To: to, TokenId: nft.TokenId, EndedLease: endedLease})
+}
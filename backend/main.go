@@ -3,15 +3,19 @@ package main
 import (
 	"log"
 
+	"krc20/contract"
+
 	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
 )
 
 func main() {
-	contract := kalpsdk.Contract{IsPayableContract: false}
-	contract.Logger = kalpsdk.NewLogger()
+	// Payable mode would require a PaymentTracker on every transaction, so fiat-settled
+	// purchases are handled by BuyNFTWithPayment instead
+	kalpContract := kalpsdk.Contract{IsPayableContract: false}
+	kalpContract.Logger = kalpsdk.NewLogger()
 
 	// Create a new instance of your SmartContract
-	smartContract := &TokenERC721Contract{contract, "3a94baaef8c1ac6fd16bbf8dc6c6393655f65ab0"}
+	smartContract := contract.NewTokenERC721Contract(kalpContract, "3a94baaef8c1ac6fd16bbf8dc6c6393655f65ab0")
 
 	// Create a new instance of KalpContractChaincode with your smart contract
 	chaincode, err := kalpsdk.NewChaincode(smartContract)
@@ -19,7 +23,7 @@ func main() {
 		log.Panicf("Error creating KalpContractChaincode: %v", err)
 	}
 
-	contract.Logger.Info("Initializing Kalp DLT Greeting Smart Contract")
+	kalpContract.Logger.Info("Initializing Kalp DLT Greeting Smart Contract")
 
 	// Start the chaincode
 	if err := chaincode.Start(); err != nil {
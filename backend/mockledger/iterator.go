@@ -0,0 +1,57 @@
+package mockledger
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// stateIterator iterates over a snapshot of committed key/value pairs
+type stateIterator struct {
+	results []*queryresult.KV
+	next    int
+	closed  bool
+}
+
+func (it *stateIterator) HasNext() bool {
+	return !it.closed && it.next < len(it.results)
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	result := it.results[it.next]
+	it.next++
+	return result, nil
+}
+
+func (it *stateIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+// historyIterator iterates over the committed modifications of a key
+type historyIterator struct {
+	results []*queryresult.KeyModification
+	next    int
+	closed  bool
+}
+
+func (it *historyIterator) HasNext() bool {
+	return !it.closed && it.next < len(it.results)
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	result := it.results[it.next]
+	it.next++
+	return result, nil
+}
+
+func (it *historyIterator) Close() error {
+	it.closed = true
+	return nil
+}
@@ -0,0 +1,218 @@
+// Package mockledger is an in-memory implementation of kalpsdk.TransactionContextInterface
+// for exercising contracts without a Kalp peer.
+//
+// A Ledger holds committed world state. Each Transaction reads the committed state only,
+// the same as a Fabric peer, and its writes become visible once the Ledger commits it.
+package mockledger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+// DefaultChannel is the channel id reported by every transaction
+const DefaultChannel = "kalp"
+
+// Event is a chaincode event emitted by a committed transaction
+type Event struct {
+	TxID    string
+	Name    string
+	Payload []byte
+}
+
+// InvokeFunc stubs cross-chaincode calls made through InvokeChaincode
+type InvokeFunc func(chaincodeName string, args [][]byte, channel string) peer.Response
+
+// Ledger is the committed world state shared by every transaction
+type Ledger struct {
+	mu      sync.Mutex
+	state   map[string][]byte
+	private map[string]map[string][]byte // Private data collections, keyed by collection
+	history map[string][]*queryresult.KeyModification
+	events  []Event
+	kyc     map[string]bool
+	now     time.Time
+	txCount int
+
+	// InvokeChaincode handles calls to chaincodes other than kyc. The default returns status 200 with an empty payload.
+	InvokeChaincode InvokeFunc
+}
+
+// New creates an empty ledger whose clock starts at start
+func New(start time.Time) *Ledger {
+	return &Ledger{
+		state:   make(map[string][]byte),
+		history: make(map[string][]*queryresult.KeyModification),
+		kyc:     make(map[string]bool),
+		now:     start,
+	}
+}
+
+// SetTime sets the timestamp given to new transactions
+func (l *Ledger) SetTime(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.now = now
+}
+
+// Advance moves the ledger clock forward
+func (l *Ledger) Advance(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.now = l.now.Add(d)
+}
+
+// Now returns the timestamp given to new transactions
+func (l *Ledger) Now() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.now
+}
+
+// SetKYC records whether the user has completed KYC, as answered by GetKYC
+func (l *Ledger) SetKYC(userID string, completed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.kyc[userID] = completed
+}
+
+// NewTransaction starts a transaction submitted by userID
+func (l *Ledger) NewTransaction(userID string) *Transaction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.txCount++
+	return &Transaction{
+		ledger:    l,
+		txID:      fmt.Sprintf("tx%d", l.txCount),
+		userID:    userID,
+		timestamp: l.now,
+		writes:    make(map[string]*write),
+	}
+}
+
+// Submit runs fn in a new transaction submitted by userID and commits it if fn succeeds
+func (l *Ledger) Submit(userID string, fn func(ctx kalpsdk.TransactionContextInterface) error) error {
+	tx := l.NewTransaction(userID)
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	return l.Commit(tx)
+}
+
+// Commit applies the writes of tx to the world state, records key history and captures its event
+func (l *Ledger) Commit(tx *Transaction) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if tx.ledger != l {
+		return fmt.Errorf("transaction %s belongs to another ledger", tx.txID)
+	}
+	if tx.committed {
+		return fmt.Errorf("transaction %s is already committed", tx.txID)
+	}
+	tx.committed = true
+
+	for _, key := range tx.WrittenKeys() {
+		w := tx.writes[key]
+		if w.deleted {
+			delete(l.state, key)
+		} else {
+			l.state[key] = w.value
+		}
+		l.history[key] = append(l.history[key], &queryresult.KeyModification{
+			TxId:      tx.txID,
+			Value:     w.value,
+			Timestamp: timestamp(tx.timestamp),
+			IsDelete:  w.deleted,
+		})
+	}
+
+	l.commitPrivate(tx)
+
+	if tx.event != nil {
+		l.events = append(l.events, *tx.event)
+	}
+
+	return nil
+}
+
+// Events returns the events of every committed transaction, oldest first
+func (l *Ledger) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.events...)
+}
+
+// Get returns the committed value of key, or nil if it does not exist
+func (l *Ledger) Get(key string) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return copyBytes(l.state[key])
+}
+
+// Put writes key directly to the committed state, bypassing transactions
+func (l *Ledger) Put(key string, value []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state[key] = copyBytes(value)
+}
+
+// Keys returns every committed key in sorted order
+func (l *Ledger) Keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make([]string, 0, len(l.state))
+	for key := range l.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rangeQuery returns the committed entries with startKey <= key < endKey in key order
+func (l *Ledger) rangeQuery(startKey string, endKey string) []*queryresult.KV {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var results []*queryresult.KV
+	for key, value := range l.state {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			results = append(results, &queryresult.KV{Key: key, Value: copyBytes(value)})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Key < results[j].Key
+	})
+	return results
+}
+
+func (l *Ledger) keyHistory(key string) []*queryresult.KeyModification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	modifications := l.history[key]
+	results := make([]*queryresult.KeyModification, len(modifications))
+	for i, modification := range modifications {
+		results[len(modifications)-1-i] = modification
+	}
+	return results
+}
+
+func (l *Ledger) hasKYC(userID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.kyc[userID]
+}
+
+func copyBytes(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append([]byte(nil), value...)
+}
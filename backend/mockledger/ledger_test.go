@@ -0,0 +1,171 @@
+package mockledger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+)
+
+func TestCreateCompositeKeyMatchesShim(t *testing.T) {
+	tests := []struct {
+		objectType string
+		attributes []string
+	}{
+		{"nft", []string{"1"}},
+		{"balance", []string{"alice", "42"}},
+		{"sale", nil},
+	}
+
+	stub := &shim.ChaincodeStub{}
+	tx := New(time.Unix(0, 0)).NewTransaction("alice")
+	for _, tt := range tests {
+		want, err := stub.CreateCompositeKey(tt.objectType, tt.attributes)
+		if err != nil {
+			t.Fatalf("shim CreateCompositeKey(%q, %q): %v", tt.objectType, tt.attributes, err)
+		}
+		got, err := tx.CreateCompositeKey(tt.objectType, tt.attributes)
+		if err != nil {
+			t.Fatalf("CreateCompositeKey(%q, %q): %v", tt.objectType, tt.attributes, err)
+		}
+		if got != want {
+			t.Errorf("CreateCompositeKey(%q, %q) = %q, want %q", tt.objectType, tt.attributes, got, want)
+		}
+
+		objectType, attributes, err := tx.SplitCompositeKey(got)
+		if err != nil {
+			t.Fatalf("SplitCompositeKey(%q): %v", got, err)
+		}
+		if objectType != tt.objectType || len(attributes) != len(tt.attributes) {
+			t.Errorf("SplitCompositeKey(%q) = %q, %q", got, objectType, attributes)
+		}
+	}
+
+	if _, err := tx.CreateCompositeKey("nft", []string{"a\x00b"}); err == nil {
+		t.Error("CreateCompositeKey accepted an attribute containing U+0000")
+	}
+}
+
+func TestTransactionReadsCommittedStateOnly(t *testing.T) {
+	ledger := New(time.Unix(0, 0))
+	tx := ledger.NewTransaction("alice")
+
+	if err := tx.PutStateWithoutKYC("name", []byte("EstateX")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := tx.GetState("name"); value != nil {
+		t.Errorf("GetState saw the uncommitted write %q", value)
+	}
+
+	if err := ledger.Commit(tx); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := ledger.NewTransaction("alice").GetState("name"); string(value) != "EstateX" {
+		t.Errorf("GetState after commit = %q, want %q", value, "EstateX")
+	}
+	if err := ledger.Commit(tx); err == nil {
+		t.Error("Commit accepted a transaction twice")
+	}
+}
+
+func TestIteratorsAndHistory(t *testing.T) {
+	ledger := New(time.Unix(100, 0))
+	err := ledger.Submit("alice", func(ctx kalpsdk.TransactionContextInterface) error {
+		for _, id := range []string{"2", "1"} {
+			key, _ := ctx.CreateCompositeKey("nft", []string{id})
+			if err := ctx.PutStateWithoutKYC(key, []byte(id)); err != nil {
+				return err
+			}
+		}
+		if err := ctx.SetEvent("Minted", []byte("2")); err != nil {
+			return err
+		}
+		return ctx.PutStateWithoutKYC("symbol", []byte("EX"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.Advance(time.Minute)
+	err = ledger.Submit("alice", func(ctx kalpsdk.TransactionContextInterface) error {
+		return ctx.DelStateWithoutKYC("symbol")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := ledger.NewTransaction("bob")
+	iterator, err := tx.GetStateByPartialCompositeKey("nft", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, string(kv.Value))
+	}
+	if len(values) != 2 || values[0] != "1" || values[1] != "2" {
+		t.Errorf("partial composite key values = %q, want [1 2]", values)
+	}
+
+	rangeIterator, err := tx.GetStateByRange("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rangeIterator.HasNext() {
+		kv, _ := rangeIterator.Next()
+		t.Errorf("range query returned %q, want no simple keys", kv.Key)
+	}
+
+	history, err := tx.GetHistoryForKey("symbol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var modifications []bool
+	for history.HasNext() {
+		modification, err := history.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		modifications = append(modifications, modification.IsDelete)
+	}
+	if len(modifications) != 2 || !modifications[0] || modifications[1] {
+		t.Errorf("history deletes = %v, want [true false]", modifications)
+	}
+
+	events := ledger.Events()
+	if len(events) != 1 || events[0].Name != "Minted" {
+		t.Errorf("events = %+v, want one Minted event", events)
+	}
+}
+
+func TestIdentityAndKYC(t *testing.T) {
+	ledger := New(time.Unix(0, 0))
+	tx := ledger.NewTransaction("alice")
+
+	userID, err := tx.GetUserID()
+	if err != nil || userID != "alice" {
+		t.Errorf("GetUserID() = %q, %v", userID, err)
+	}
+
+	if err := tx.PutStateWithKYC("key", []byte("value")); err == nil {
+		t.Error("PutStateWithKYC succeeded without KYC")
+	}
+	if err := tx.PutKYC("alice", "kyc1", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := tx.GetKYC("alice"); !ok {
+		t.Error("GetKYC after PutKYC = false")
+	}
+	if err := tx.PutStateWithKYC("key", []byte("value")); err != nil {
+		t.Errorf("PutStateWithKYC after KYC: %v", err)
+	}
+
+	ts, _ := tx.GetTxTimestamp()
+	if ts.AsTime() != time.Unix(0, 0).UTC() {
+		t.Errorf("GetTxTimestamp() = %v", ts.AsTime())
+	}
+}
@@ -0,0 +1,141 @@
+package mockledger
+
+import (
+	"crypto/sha256"
+	"sort"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// stub exposes the private data and transient data of a transaction through the shim stub interface.
+// Only those methods are implemented; the others panic.
+type stub struct {
+	shim.ChaincodeStubInterface
+	tx *Transaction
+}
+
+// GetStub returns a shim stub for the private data collections and transient data of the transaction,
+// the same as kalpsdk.TransactionContext.GetStub
+func (tx *Transaction) GetStub() shim.ChaincodeStubInterface {
+	return &stub{tx: tx}
+}
+
+// SetTransient sets the transient data returned by GetTransient
+func (tx *Transaction) SetTransient(transient map[string][]byte) {
+	tx.transient = transient
+}
+
+// PrivateWrittenKeys returns the keys of collection written or deleted by the transaction in sorted order
+func (tx *Transaction) PrivateWrittenKeys(collection string) []string {
+	keys := make([]string, 0, len(tx.privateWrites[collection]))
+	for key := range tx.privateWrites[collection] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetPrivate returns the committed value of key in collection, or nil if it does not exist
+func (l *Ledger) GetPrivate(collection string, key string) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return copyBytes(l.private[collection][key])
+}
+
+// PrivateKeys returns every committed key of collection in sorted order
+func (l *Ledger) PrivateKeys(collection string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make([]string, 0, len(l.private[collection]))
+	for key := range l.private[collection] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetPrivateData reads committed private data only, like GetState
+func (s *stub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return s.tx.ledger.GetPrivate(collection, key), nil
+}
+
+// GetPrivateDataHash returns the SHA-256 of the committed private value, which every channel member can read
+func (s *stub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value := s.tx.ledger.GetPrivate(collection, key)
+	if value == nil {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+// GetPrivateDataByPartialCompositeKey iterates over the committed private data under a composite key prefix
+func (s *stub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, err := s.tx.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	endKey := startKey + string(maxUnicodeRuneValue)
+
+	l := s.tx.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var results []*queryresult.KV
+	for key, value := range l.private[collection] {
+		if key >= startKey && key < endKey {
+			results = append(results, &queryresult.KV{Key: key, Value: copyBytes(value)})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Key < results[j].Key
+	})
+	return &stateIterator{results: results}, nil
+}
+
+func (s *stub) PutPrivateData(collection string, key string, value []byte) error {
+	s.tx.putPrivate(collection, key, &write{value: copyBytes(value)})
+	return nil
+}
+
+func (s *stub) DelPrivateData(collection string, key string) error {
+	s.tx.putPrivate(collection, key, &write{deleted: true})
+	return nil
+}
+
+func (s *stub) GetTransient() (map[string][]byte, error) {
+	transient := make(map[string][]byte, len(s.tx.transient))
+	for key, value := range s.tx.transient {
+		transient[key] = copyBytes(value)
+	}
+	return transient, nil
+}
+
+func (tx *Transaction) putPrivate(collection string, key string, w *write) {
+	if tx.privateWrites == nil {
+		tx.privateWrites = make(map[string]map[string]*write)
+	}
+	if tx.privateWrites[collection] == nil {
+		tx.privateWrites[collection] = make(map[string]*write)
+	}
+	tx.privateWrites[collection][key] = w
+}
+
+// commitPrivate applies the private writes of tx. The caller holds the ledger lock.
+func (l *Ledger) commitPrivate(tx *Transaction) {
+	for collection, writes := range tx.privateWrites {
+		if l.private == nil {
+			l.private = make(map[string]map[string][]byte)
+		}
+		if l.private[collection] == nil {
+			l.private[collection] = make(map[string][]byte)
+		}
+		for key, w := range writes {
+			if w.deleted {
+				delete(l.private[collection], key)
+			} else {
+				l.private[collection][key] = w.value
+			}
+		}
+	}
+}
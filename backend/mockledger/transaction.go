@@ -0,0 +1,291 @@
+package mockledger
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/p2eengineering/kalp-sdk-public/kalpsdk"
+	res "github.com/p2eengineering/kalp-sdk-public/response"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Composite key encoding used by the Fabric shim
+const (
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue   = 0            // U+0000
+	maxUnicodeRuneValue   = utf8.MaxRune // U+10FFFF
+	emptyKeySubstitute    = "\x01"
+)
+
+type write struct {
+	value   []byte
+	deleted bool
+}
+
+// Transaction implements kalpsdk.TransactionContextInterface for one simulated transaction
+type Transaction struct {
+	ledger    *Ledger
+	txID      string
+	userID    string
+	timestamp time.Time
+	writes    map[string]*write
+	event     *Event
+	committed bool
+
+	privateWrites map[string]map[string]*write // Keyed by collection
+	transient     map[string][]byte
+
+	function string
+	args     []string
+}
+
+var _ kalpsdk.TransactionContextInterface = (*Transaction)(nil)
+
+// SetFunctionAndParameters sets the values returned by GetFunctionAndParameters
+func (tx *Transaction) SetFunctionAndParameters(function string, args []string) {
+	tx.function = function
+	tx.args = args
+}
+
+// WrittenKeys returns the keys written or deleted by the transaction in sorted order
+func (tx *Transaction) WrittenKeys() []string {
+	keys := make([]string, 0, len(tx.writes))
+	for key := range tx.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Written returns the value the transaction wrote to key and whether it deleted it
+func (tx *Transaction) Written(key string) (value []byte, deleted bool, ok bool) {
+	w, ok := tx.writes[key]
+	if !ok {
+		return nil, false, false
+	}
+	return copyBytes(w.value), w.deleted, true
+}
+
+// Event returns the event set by the transaction, if any
+func (tx *Transaction) Event() *Event {
+	return tx.event
+}
+
+func (tx *Transaction) PutStateWithKYC(key string, value []byte) error {
+	if !tx.ledger.hasKYC(tx.userID) {
+		return fmt.Errorf("user %s has not completed KYC", tx.userID)
+	}
+	return tx.PutStateWithoutKYC(key, value)
+}
+
+func (tx *Transaction) PutStateWithoutKYC(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	tx.writes[key] = &write{value: copyBytes(value)}
+	return nil
+}
+
+func (tx *Transaction) GetKYC(userId string) (bool, error) {
+	return tx.ledger.hasKYC(userId), nil
+}
+
+// PutKYC stands in for the kyc chaincode and marks the user as KYC complete
+func (tx *Transaction) PutKYC(id string, kycId string, kycHash string) error {
+	if tx.ledger.hasKYC(id) {
+		return fmt.Errorf("kyc already exists for user %s", id)
+	}
+	tx.ledger.SetKYC(id, true)
+	return nil
+}
+
+func (tx *Transaction) DelStateWithoutKYC(key string) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	tx.writes[key] = &write{deleted: true}
+	return nil
+}
+
+func (tx *Transaction) DelStateWithKYC(key string) error {
+	if !tx.ledger.hasKYC(tx.userID) {
+		return fmt.Errorf("user %s has not completed KYC", tx.userID)
+	}
+	return tx.DelStateWithoutKYC(key)
+}
+
+// GetState returns the committed value of key. Like a Fabric peer, it does not see the transaction's own writes.
+func (tx *Transaction) GetState(key string) ([]byte, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key must not be an empty string")
+	}
+	return tx.ledger.Get(key), nil
+}
+
+// SetEvent sets the transaction's event. Fabric allows one event per transaction, so a later call replaces it.
+func (tx *Transaction) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return fmt.Errorf("event name can not be empty string")
+	}
+	tx.event = &Event{TxID: tx.txID, Name: name, Payload: copyBytes(payload)}
+	return nil
+}
+
+func (tx *Transaction) GetTxID() string {
+	return tx.txID
+}
+
+func (tx *Transaction) GetChannelID() string {
+	return DefaultChannel
+}
+
+func (tx *Transaction) GetUserID() (string, error) {
+	if tx.userID == "" {
+		return "", fmt.Errorf("failed to read clientID: no identity")
+	}
+	return tx.userID, nil
+}
+
+// InvokeChaincode answers the kyc chaincode from the ledger's KYC records and passes other calls to Ledger.InvokeChaincode
+func (tx *Transaction) InvokeChaincode(chaincodeName string, args [][]byte, channel string) res.Response {
+	if chaincodeName == "kyc" && len(args) == 2 && string(args[0]) == "KycExists" {
+		return res.Response{Response: peer.Response{Status: 200, Payload: []byte(strconv.FormatBool(tx.ledger.hasKYC(string(args[1]))))}}
+	}
+	if tx.ledger.InvokeChaincode != nil {
+		return res.Response{Response: tx.ledger.InvokeChaincode(chaincodeName, args, channel)}
+	}
+	return res.Response{Response: peer.Response{Status: 200}}
+}
+
+func (tx *Transaction) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	key := compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue))
+	for _, attribute := range attributes {
+		if err := validateCompositeKeyAttribute(attribute); err != nil {
+			return "", err
+		}
+		key += attribute + string(rune(minUnicodeRuneValue))
+	}
+	return key, nil
+}
+
+func (tx *Transaction) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if len(compositeKey) == 0 || compositeKey[:1] != compositeKeyNamespace {
+		return "", nil, fmt.Errorf("key %q is not a composite key", compositeKey)
+	}
+	componentIndex := 1
+	var components []string
+	for i := 1; i < len(compositeKey); i++ {
+		if compositeKey[i] == minUnicodeRuneValue {
+			components = append(components, compositeKey[componentIndex:i])
+			componentIndex = i + 1
+		}
+	}
+	if len(components) == 0 {
+		return "", nil, fmt.Errorf("key %q is not a composite key", compositeKey)
+	}
+	return components[0], components[1:], nil
+}
+
+func (tx *Transaction) GetStateByPartialCompositeKey(objectType string, keys []string) (kalpsdk.StateQueryIteratorInterface, error) {
+	startKey, err := tx.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	endKey := startKey + string(maxUnicodeRuneValue)
+	return &stateIterator{results: tx.ledger.rangeQuery(startKey, endKey)}, nil
+}
+
+// GetStateByRange iterates over the simple keys in [startKey, endKey). Composite keys are never returned.
+func (tx *Transaction) GetStateByRange(startKey string, endKey string) (kalpsdk.StateQueryIteratorInterface, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	for _, key := range []string{startKey, endKey} {
+		if len(key) > 0 && key[:1] == compositeKeyNamespace {
+			return nil, fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	return &stateIterator{results: tx.ledger.rangeQuery(startKey, endKey)}, nil
+}
+
+// GetQueryResult is not supported because rich queries need CouchDB
+func (tx *Transaction) GetQueryResult(query string) (kalpsdk.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("rich queries are not supported by the mock ledger")
+}
+
+// GetHistoryForKey returns the committed modifications of key, newest first as Fabric v2 does
+func (tx *Transaction) GetHistoryForKey(key string) (kalpsdk.HistoryQueryIteratorInterface, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key must not be an empty string")
+	}
+	return &historyIterator{results: tx.ledger.keyHistory(key)}, nil
+}
+
+func (tx *Transaction) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamp(tx.timestamp), nil
+}
+
+func (tx *Transaction) GetFunctionAndParameters() (string, []string) {
+	return tx.function, tx.args
+}
+
+func (tx *Transaction) ValidateCreateTokenTransaction(id string, docType string, account []string) error {
+	return nil
+}
+
+func (tx *Transaction) GetClientIdentity() cid.ClientIdentity {
+	return &clientIdentity{userID: tx.userID}
+}
+
+func validateCompositeKeyAttribute(str string) error {
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", str)
+	}
+	for index, runeValue := range str {
+		if runeValue == minUnicodeRuneValue || runeValue == maxUnicodeRuneValue {
+			return fmt.Errorf(`input contains unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key`,
+				runeValue, index, minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	return timestamppb.New(t)
+}
+
+// clientIdentity encodes the user id the same way as a Kalp X.509 identity, so kalpsdk.GetUserID can decode it
+type clientIdentity struct {
+	userID string
+}
+
+func (ci *clientIdentity) GetID() (string, error) {
+	id := fmt.Sprintf("x509::CN=%s,OU=client::CN=ca.kalp", ci.userID)
+	return base64.StdEncoding.EncodeToString([]byte(id)), nil
+}
+
+func (ci *clientIdentity) GetMSPID() (string, error) {
+	return "KalpMSP", nil
+}
+
+func (ci *clientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (ci *clientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	return fmt.Errorf("attribute '%s' was not found", attrName)
+}
+
+func (ci *clientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return nil, nil
+}
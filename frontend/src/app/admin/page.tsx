@@ -179,6 +179,11 @@ export default function PendingApprovalPage() {
                     <p className="text-sm font-semibold text-gray-800 mb-2">
                       Earnest: {sale.earnest} ETH
                     </p>
+                    {[sale.priceFlag, sale.earnestFlag].filter(Boolean).map((flag) => (
+                      <p key={flag} className="text-sm font-semibold text-amber-600 mb-2">
+                        Price check: {flag}
+                      </p>
+                    ))}
                   </CardContent>
                   <CardFooter className="flex justify-between">
                     <div className="text-sm text-gray-500">
@@ -47,7 +47,7 @@ export const useKalpApi = () => {
     }
   };
 
-  const mintWithTokenURIWithDetails = async (tokenId: string, name: string, address: string, description: string, image: string, residenceType: string, bedrooms: number, bathrooms: number, squareFeet: number, yearBuilt: number) => {
+  const mintWithTokenURIWithDetails = async (tokenId: string, name: string, address: string, description: string, image: string, residenceType: string, bedrooms: number, bathrooms: number, squareFeet: number, yearBuilt: number, royaltyReceiver: string, royaltyBasisPoints: number) => {
     const endpoint =
       'https://gateway-api.kalp.studio/v1/contract/kalp/invoke/0Azlv5TZKM5Ye6j54r0BUwgV1e5zcP481727064711138/MintWithTokenURIWithDetails';
     const args = {
@@ -60,7 +60,9 @@ export const useKalpApi = () => {
       bedrooms : bedrooms,
       bathrooms : bathrooms,
       squareFeet : squareFeet,
-      yearBuilt : yearBuilt
+      yearBuilt : yearBuilt,
+      royaltyReceiver : royaltyReceiver,
+      royaltyBasisPoints : royaltyBasisPoints
     };
     return callApi(endpoint, args);
   };
@@ -99,6 +101,18 @@ export const useKalpApi = () => {
     return callApi(endpoint, args);
   };
 
+  // Only accepted when invoked with the payment engine's credentials
+  const buyNFTWithPayment = async (tokenId: string, buyer: string, paymentTracker: object) => {
+    const endpoint =
+      'https://gateway-api.kalp.studio/v1/contract/kalp/invoke/0Azlv5TZKM5Ye6j54r0BUwgV1e5zcP481727064711138/BuyNFTWithPayment';
+    const args = {
+      tokenId: tokenId,
+      buyer: buyer,
+      paymentTracker: JSON.stringify(paymentTracker)
+    };
+    return callApi(endpoint, args);
+  };
+
   const getPendingApprovalNFTs = async () => {
     const endpoint =
       'https://gateway-api.kalp.studio/v1/contract/kalp/query/0Azlv5TZKM5Ye6j54r0BUwgV1e5zcP481727064711138/GetPendingApprovalNFTs';
@@ -116,5 +130,24 @@ export const useKalpApi = () => {
     return callApi(endpoint, args);
   };
 
-  return {mintWithTokenURIWithDetails, listNFTForSale, getAllNFTs, getNFTsOnSale, buyNFT, getPendingApprovalNFTs, approveSale };
+  const getKYCStatus = async (userId: string) => {
+    const endpoint =
+      'https://gateway-api.kalp.studio/v1/contract/kalp/query/0Azlv5TZKM5Ye6j54r0BUwgV1e5zcP481727064711138/GetKYCStatus';
+    const args = {
+      userId: userId
+    };
+    return callApi(endpoint, args);
+  };
+
+  const royaltyInfo = async (tokenId: string, salePrice: number) => {
+    const endpoint =
+      'https://gateway-api.kalp.studio/v1/contract/kalp/query/0Azlv5TZKM5Ye6j54r0BUwgV1e5zcP481727064711138/RoyaltyInfo';
+    const args = {
+      tokenId: tokenId,
+      salePrice: salePrice
+    };
+    return callApi(endpoint, args);
+  };
+
+  return {mintWithTokenURIWithDetails, listNFTForSale, getAllNFTs, getNFTsOnSale, buyNFT, buyNFTWithPayment, getPendingApprovalNFTs, approveSale, getKYCStatus, royaltyInfo };
 };
\ No newline at end of file
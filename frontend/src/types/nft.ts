@@ -28,6 +28,8 @@ export interface NFTAttribute {
     earnest: number;
     buyer: string;
     isApproved: string;
+    priceFlag?: string;
+    earnestFlag?: string;
   }
   
   export interface NFTData {
@@ -25,4 +25,30 @@ export interface KalpApiResponse<T = unknown> {
   
     const data = await response.json();
     return { status: response.status, data };
-  }
\ No newline at end of file
+  }
+export type ContractErrorCode =
+  | 'NOT_FOUND'
+  | 'UNAUTHORIZED'
+  | 'INVALID_STATE'
+  | 'VALIDATION'
+  | 'CONFLICT'
+  | 'INTERNAL';
+
+export interface ContractError {
+  code: ContractErrorCode;
+  message: string;
+  details?: Record<string, unknown>;
+}
+
+// The chaincode returns errors as a JSON object, which the gateway may embed in a longer message
+export function parseContractError(message: string): ContractError | null {
+  const start = message.indexOf('{"code"');
+  if (start === -1) {
+    return null;
+  }
+  try {
+    return JSON.parse(message.slice(start, message.lastIndexOf('}') + 1)) as ContractError;
+  } catch {
+    return null;
+  }
+}